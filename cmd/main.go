@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,6 +15,7 @@ import (
 	"github.com/prefeitura-rio/app-ext-authz/internal/config"
 	"github.com/prefeitura-rio/app-ext-authz/internal/handlers"
 	"github.com/prefeitura-rio/app-ext-authz/internal/service"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -42,7 +44,32 @@ func main() {
 	}
 
 	// Create handler
-	handler := handlers.NewHandler(svc)
+	handlerConfig := handlers.Config{
+		PathPrefix:          cfg.AuthzPathPrefix,
+		PolicyHeader:        cfg.PolicyHeader,
+		SiteKeyHeader:       cfg.SiteKeyHeader,
+		AnnotationAPIKey:    cfg.AnnotationAPIKey,
+		PasswordLeakAPIKey:  cfg.PasswordLeakAPIKey,
+		ReasonsHeader:       cfg.ReasonsHeader,
+		AccountLabelsHeader: cfg.AccountLabelsHeader,
+		TrustedProxies:      cfg.TrustedProxies,
+		JA3Header:           cfg.JA3Header,
+		TokenHeaders: []handlers.TokenHeader{
+			{Provider: "recaptcha", Header: "X-Recaptcha-Token"},
+		},
+	}
+	if cfg.AccountIDHMACKey != "" {
+		handlerConfig.AccountIDHeader = cfg.AccountIDHeader
+	}
+	if cfg.TurnstileSecretKey != "" {
+		handlerConfig.TokenHeaders = append(handlerConfig.TokenHeaders,
+			handlers.TokenHeader{Provider: "turnstile", Header: cfg.TurnstileTokenHeader})
+	}
+	if cfg.HCaptchaSecretKey != "" {
+		handlerConfig.TokenHeaders = append(handlerConfig.TokenHeaders,
+			handlers.TokenHeader{Provider: "hcaptcha", Header: cfg.HCaptchaTokenHeader})
+	}
+	handler := handlers.NewHandler(svc, handlerConfig)
 
 	// Create router
 	router := gin.New()
@@ -69,6 +96,26 @@ func main() {
 		}
 	}()
 
+	// Start gRPC ext_authz server
+	var grpcServer *grpc.Server
+	if cfg.GRPCEnabled {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			log.Fatalf("Failed to listen on gRPC port %d: %v", cfg.GRPCPort, err)
+		}
+
+		grpcServer = grpc.NewServer()
+		handlers.NewGRPCHandler(svc, handlerConfig).Register(grpcServer)
+
+		go func() {
+			log.Printf("Starting gRPC server on port %d", cfg.GRPCPort)
+
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -85,6 +132,11 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Shutdown gRPC server
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+
 	// Shutdown service
 	if err := svc.Shutdown(ctx); err != nil {
 		log.Printf("Service shutdown error: %v", err)
@@ -1,14 +1,19 @@
 package cache
 
 import (
+	"container/list"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/prefeitura-rio/app-ext-authz/internal/circuitbreaker"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -19,17 +24,49 @@ type Cache interface {
 	Delete(ctx context.Context, key string) error
 	Clear(ctx context.Context) error
 	GetStats() Stats
+
+	// Consume atomically records one use of key, which expires ttl after its
+	// first use, and returns the number of uses so far and when the first
+	// use happened
+	Consume(ctx context.Context, key string, ttl time.Duration) (int64, time.Time, error)
+
+	// Lock acquires an exclusive lock on key, which expires after ttl, and
+	// returns a function releasing it, or ErrLocked while another holder
+	// has it
+	Lock(ctx context.Context, key string, ttl time.Duration) (func(), error)
+
+	// Close releases the cache's connections and background work
+	Close() error
 }
 
+var (
+	// ErrCacheMiss is returned by Get when the key is not cached
+	ErrCacheMiss = errors.New("cache miss")
+
+	// ErrCacheUnavailable is returned while the cache is bypassed after
+	// Redis failures
+	ErrCacheUnavailable = errors.New("cache is unavailable")
+
+	// ErrLocked is returned by Lock when the key is already locked
+	ErrLocked = errors.New("key is locked")
+)
+
 // ValidationResult represents a cached validation result
 type ValidationResult struct {
 	Success     bool      `json:"success"`
 	Score       float64   `json:"score,omitempty"`
+	Scored      bool      `json:"scored,omitempty"`
 	Action      string    `json:"action,omitempty"`
 	ChallengeTS string    `json:"challenge_ts,omitempty"`
 	Hostname    string    `json:"hostname,omitempty"`
 	ErrorCodes  []string  `json:"error_codes,omitempty"`
+	Provider    string    `json:"provider,omitempty"`
+	Assessment  string    `json:"assessment,omitempty"`
 	Timestamp   time.Time `json:"timestamp"`
+
+	Reasons                []string `json:"reasons,omitempty"`
+	ExtendedVerdictReasons []string `json:"extended_verdict_reasons,omitempty"`
+	AccountLabels          []string `json:"account_labels,omitempty"`
 }
 
 // Stats represents cache statistics
@@ -37,59 +74,124 @@ type Stats struct {
 	Hits   int64 `json:"hits"`
 	Misses int64 `json:"misses"`
 	Size   int64 `json:"size"`
+
+	// Degraded is set while Redis is unavailable and the cache is bypassed
+	Degraded bool `json:"degraded"`
+
+	// Per-tier stats of a layered cache: the in-process LRU and Redis
+	L1 *Stats `json:"l1,omitempty"`
+	L2 *Stats `json:"l2,omitempty"`
 }
 
 // Config holds cache configuration
 type Config struct {
-	Type           string        // "memory" or "redis"
-	RedisURL       string        // Redis connection URL
+	Type           string        // "memory", "redis", "layered" or "none"
+	RedisURL       string        // Redis connection URL, for a single node
 	DefaultTTL     time.Duration // Default TTL for successful validations
 	FailedTTL      time.Duration // TTL for failed validations
 	MaxMemorySize  int           // Maximum number of items in memory cache
+	LocalTTL       time.Duration // Maximum TTL of the in-process tier of a layered cache
+
+	// KeyPrefix namespaces Redis keys, e.g. "recaptcha-authz:production";
+	// the schema version is appended
+	KeyPrefix string
+
+	// Redis resilience: the timeout of each call, and the breaker that
+	// bypasses Redis after consecutive failures
+	OperationTimeout        time.Duration
+	BreakerFailureThreshold int
+	BreakerRecoveryTime     time.Duration
+
+	// Redis topology: "standalone" (RedisURL), "sentinel" (RedisAddrs are
+	// the sentinels of RedisMasterName) or "cluster" (RedisAddrs are
+	// cluster nodes). Credentials and TLS apply to every mode, and override
+	// those of the URL.
+	RedisMode             string
+	RedisAddrs            []string
+	RedisMasterName       string
+	RedisSentinelPassword string
+	RedisDB               int // Sentinel only; a single node's comes from the URL
+	RedisUsername         string
+	RedisPassword         string
+	RedisTLS              bool
+	RedisTLSCAFile        string
+	RedisTLSServerName    string
+
+	// Redis connection pool; zero values keep the client defaults
+	RedisPoolSize         int
+	RedisMinIdleConns     int
+	RedisReadTimeout      time.Duration
+	RedisWriteTimeout     time.Duration
+	RedisReadFromReplicas bool // Sentinel and Cluster only
 }
 
-// memoryCache implements in-memory caching
+// memoryCache implements in-memory caching, evicting the least recently used
+// entry when full
 type memoryCache struct {
 	config Config
-	data   map[string]*cacheEntry
-	mu     sync.RWMutex
+	data   map[string]*list.Element
+	order  *list.List // Entries, most recently used first
+	uses   map[string]*useEntry
+	locks  map[string]*lockEntry
+	mu     sync.Mutex
 	stats  Stats
+
+	// Size of uses at which Consume next removes its expired entries
+	usesSweepAt int
 }
 
+// minUsesSweep is the smallest size of the uses map that is swept
+const minUsesSweep = 1024
+
 type cacheEntry struct {
+	key       string
 	result    *ValidationResult
 	expiresAt time.Time
 }
 
-// NewMemoryCache creates a new in-memory cache
+type useEntry struct {
+	count     int64
+	firstUse  time.Time
+	expiresAt time.Time
+}
+
+type lockEntry struct {
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates a new in-memory cache holding at most
+// MaxMemorySize entries; 0 is unbounded
 func NewMemoryCache(config Config) Cache {
 	return &memoryCache{
 		config: config,
-		data:   make(map[string]*cacheEntry),
+		data:   make(map[string]*list.Element),
+		order:  list.New(),
+		uses:   make(map[string]*useEntry),
+		locks:  make(map[string]*lockEntry),
+
+		usesSweepAt: minUsesSweep,
 	}
 }
 
 func (c *memoryCache) Get(ctx context.Context, key string) (*ValidationResult, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	entry, exists := c.data[key]
+	element, exists := c.data[key]
 	if !exists {
 		c.stats.Misses++
-		return nil, fmt.Errorf("cache miss")
+		return nil, ErrCacheMiss
 	}
 
+	entry := element.Value.(*cacheEntry)
 	if time.Now().After(entry.expiresAt) {
 		// Expired entry, remove it
-		c.mu.RUnlock()
-		c.mu.Lock()
-		delete(c.data, key)
-		c.mu.Unlock()
-		c.mu.RLock()
+		c.remove(element)
 		c.stats.Misses++
-		return nil, fmt.Errorf("cache miss (expired)")
+		return nil, ErrCacheMiss
 	}
 
+	c.order.MoveToFront(element)
 	c.stats.Hits++
 	return entry.result, nil
 }
@@ -98,17 +200,24 @@ func (c *memoryCache) Set(ctx context.Context, key string, result *ValidationRes
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Check if we need to evict items
-	if int64(len(c.data)) >= int64(c.config.MaxMemorySize) {
-		c.evictOldest()
+	if element, exists := c.data[key]; exists {
+		entry := element.Value.(*cacheEntry)
+		entry.result = result
+		entry.expiresAt = time.Now().Add(ttl)
+		c.order.MoveToFront(element)
+		return nil
 	}
 
-	entry := &cacheEntry{
-		result:    result,
-		expiresAt: time.Now().Add(ttl),
+	// Evict the least recently used entry when full
+	if c.config.MaxMemorySize > 0 && len(c.data) >= c.config.MaxMemorySize {
+		c.remove(c.order.Back())
 	}
 
-	c.data[key] = entry
+	c.data[key] = c.order.PushFront(&cacheEntry{
+		key:       key,
+		result:    result,
+		expiresAt: time.Now().Add(ttl),
+	})
 	c.stats.Size = int64(len(c.data))
 
 	return nil
@@ -118,8 +227,9 @@ func (c *memoryCache) Delete(ctx context.Context, key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	delete(c.data, key)
-	c.stats.Size = int64(len(c.data))
+	if element, exists := c.data[key]; exists {
+		c.remove(element)
+	}
 	return nil
 }
 
@@ -127,14 +237,76 @@ func (c *memoryCache) Clear(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.data = make(map[string]*cacheEntry)
+	c.data = make(map[string]*list.Element)
+	c.order.Init()
+	c.uses = make(map[string]*useEntry)
+	c.usesSweepAt = minUsesSweep
+	c.locks = make(map[string]*lockEntry)
 	c.stats.Size = 0
 	return nil
 }
 
+func (c *memoryCache) Consume(ctx context.Context, key string, ttl time.Duration) (int64, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, exists := c.uses[key]
+	if !exists || now.After(entry.expiresAt) {
+		entry = &useEntry{
+			firstUse:  now,
+			expiresAt: now.Add(ttl),
+		}
+		c.uses[key] = entry
+		c.sweepUses(now)
+	}
+
+	entry.count++
+	return entry.count, entry.firstUse, nil
+}
+
+// sweepUses removes expired uses once the map has doubled in size since the
+// last sweep, which keeps the cost per Consume constant; the caller holds
+// the lock
+func (c *memoryCache) sweepUses(now time.Time) {
+	if len(c.uses) < c.usesSweepAt {
+		return
+	}
+
+	for key, entry := range c.uses {
+		if now.After(entry.expiresAt) {
+			delete(c.uses, key)
+		}
+	}
+	c.usesSweepAt = max(2*len(c.uses), minUsesSweep)
+}
+
+func (c *memoryCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, exists := c.locks[key]; exists && now.Before(entry.expiresAt) {
+		return nil, ErrLocked
+	}
+
+	entry := &lockEntry{expiresAt: now.Add(ttl)}
+	c.locks[key] = entry
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		// Only release the lock if it has not expired and been taken since
+		if c.locks[key] == entry {
+			delete(c.locks, key)
+		}
+	}, nil
+}
+
 func (c *memoryCache) GetStats() Stats {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	return Stats{
 		Hits:   c.stats.Hits,
@@ -143,64 +315,174 @@ func (c *memoryCache) GetStats() Stats {
 	}
 }
 
-func (c *memoryCache) evictOldest() {
-	// Simple LRU: remove the oldest entry
-	var oldestKey string
-	var oldestTime time.Time
-
-	for key, entry := range c.data {
-		if oldestKey == "" || entry.expiresAt.Before(oldestTime) {
-			oldestKey = key
-			oldestTime = entry.expiresAt
-		}
-	}
+func (c *memoryCache) Close() error {
+	return nil
+}
 
-	if oldestKey != "" {
-		delete(c.data, oldestKey)
-	}
+// remove deletes an entry; the caller holds the lock
+func (c *memoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.data, element.Value.(*cacheEntry).key)
+	c.stats.Size = int64(len(c.data))
 }
 
-// redisCache implements Redis caching
+// redisCache implements Redis caching. Calls go through a circuit breaker
+// with a per-call timeout, so a failing or slow Redis is bypassed instead of
+// adding latency to every request. While the breaker is not closed, Redis is
+// pinged in the background and the breaker closes once it answers.
 type redisCache struct {
-	config Config
-	client *redis.Client
-	stats  Stats
-	mu     sync.RWMutex
+	config    Config
+	namespace string // Prefix of every key, ending in the schema version
+	client    redis.UniversalClient
+	breaker   *circuitbreaker.Breaker
+	done      chan struct{}
+	stats     Stats
+	countedAt time.Time // Start of the last count of Stats.Size
+	counting  bool
+	mu        sync.RWMutex
 }
 
-// NewRedisCache creates a new Redis cache
+// Defaults for Redis calls, and the reconnection backoff bounds while Redis
+// is unavailable
+const (
+	defaultOperationTimeout    = 250 * time.Millisecond
+	defaultBreakerRecoveryTime = 10 * time.Second
+	minReconnectBackoff        = 500 * time.Millisecond
+	maxReconnectBackoff        = 30 * time.Second
+)
+
+// schemaVersion is the version of the cached data's format. Bumping it moves
+// the cache to a new keyspace, so old entries are never read back.
+const schemaVersion = 1
+
+// Kinds of Redis keys in the namespace
+const (
+	resultKeys = "result"
+	useKeys    = "use"
+	lockKeys   = "lock"
+)
+
+// How often at most the cached results are counted, and how many keys each
+// SCAN or delete batch handles
+const (
+	sizeRefreshInterval = 30 * time.Second
+	scanBatchSize       = 1000
+)
+
+// NewRedisCache creates a new Redis cache. If Redis cannot be reached the
+// cache starts degraded, bypassed until it reconnects.
 func NewRedisCache(config Config) (Cache, error) {
-	opts, err := redis.ParseURL(config.RedisURL)
+	client, err := newRedisClient(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+		return nil, err
 	}
 
-	client := redis.NewClient(opts)
+	if config.OperationTimeout <= 0 {
+		config.OperationTimeout = defaultOperationTimeout
+	}
+	if config.BreakerRecoveryTime <= 0 {
+		config.BreakerRecoveryTime = defaultBreakerRecoveryTime
+	}
+
+	prefix := config.KeyPrefix
+	if prefix == "" {
+		prefix = "recaptcha-authz"
+	}
+
+	c := &redisCache{
+		config:    config,
+		namespace: fmt.Sprintf("%s:v%d:", prefix, schemaVersion),
+		client:    client,
+		breaker: circuitbreaker.NewBreaker(circuitbreaker.Config{
+			FailureThreshold:    max(config.BreakerFailureThreshold, 1),
+			RecoveryTime:        config.BreakerRecoveryTime,
+			HalfOpenMaxRequests: 1,
+		}),
+		done: make(chan struct{}),
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		c.breaker.ForceOpen()
 	}
 
-	return &redisCache{
-		config: config,
-		client: client,
-	}, nil
+	go c.reconnect()
+
+	return c, nil
+}
+
+// reconnect pings Redis while the breaker is not closed, backing off
+// exponentially between failed pings, and closes the breaker once Redis
+// answers
+func (c *redisCache) reconnect() {
+	backoff := minReconnectBackoff
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		if c.breaker.IsClosed() {
+			backoff = minReconnectBackoff
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.OperationTimeout)
+		err := c.client.Ping(ctx).Err()
+		cancel()
+
+		if err == nil {
+			c.breaker.ForceClose()
+			backoff = minReconnectBackoff
+			continue
+		}
+
+		c.breaker.ForceOpen()
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// do runs a Redis call through the breaker, with the operation timeout.
+// redis.Nil is an answer, not a failure, and is returned as is, as are the
+// errors of calls whose context is done.
+func (c *redisCache) do(ctx context.Context, call func(ctx context.Context) error) error {
+	var callErr error
+	err := c.breaker.Execute(ctx, func() error {
+		opCtx, cancel := context.WithTimeout(ctx, c.config.OperationTimeout)
+		defer cancel()
+
+		// A request that went away says nothing about Redis
+		callErr = call(opCtx)
+		if errors.Is(callErr, redis.Nil) || ctx.Err() != nil {
+			return nil
+		}
+		return callErr
+	})
+	if err != nil && callErr == nil {
+		// Rejected by the breaker
+		return ErrCacheUnavailable
+	}
+	return callErr
 }
 
 func (c *redisCache) Get(ctx context.Context, key string) (*ValidationResult, error) {
-	data, err := c.client.Get(ctx, c.hashKey(key)).Result()
+	var data string
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		data, err = c.client.Get(ctx, c.key(resultKeys, key)).Result()
+		return err
+	})
 	if err != nil {
-		if err == redis.Nil {
+		if errors.Is(err, redis.Nil) {
 			c.mu.Lock()
 			c.stats.Misses++
 			c.mu.Unlock()
-			return nil, fmt.Errorf("cache miss")
+			return nil, ErrCacheMiss
 		}
-		return nil, fmt.Errorf("failed to get from Redis: %w", err)
+		return nil, redisError("failed to get from Redis", err)
 	}
 
 	var result ValidationResult
@@ -221,55 +503,268 @@ func (c *redisCache) Set(ctx context.Context, key string, result *ValidationResu
 		return fmt.Errorf("failed to marshal result: %w", err)
 	}
 
-	err = c.client.Set(ctx, c.hashKey(key), data, ttl).Err()
+	err = c.do(ctx, func(ctx context.Context) error {
+		return c.client.Set(ctx, c.key(resultKeys, key), data, ttl).Err()
+	})
 	if err != nil {
-		return fmt.Errorf("failed to set in Redis: %w", err)
+		return redisError("failed to set in Redis", err)
 	}
 
 	return nil
 }
 
 func (c *redisCache) Delete(ctx context.Context, key string) error {
-	err := c.client.Del(ctx, c.hashKey(key)).Err()
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.client.Del(ctx, c.key(resultKeys, key)).Err()
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete from Redis: %w", err)
+		return redisError("failed to delete from Redis", err)
 	}
 	return nil
 }
 
+// Clear deletes every key in the namespace, on every master of a cluster,
+// leaving other data in the database alone. It scans the keyspace, so it
+// bypasses the breaker and its timeout.
 func (c *redisCache) Clear(ctx context.Context) error {
-	// Note: This will clear ALL keys in the database
-	// In production, you might want to use a more targeted approach
-	err := c.client.FlushDB(ctx).Err()
+	err := c.forEachMaster(ctx, func(ctx context.Context, client redis.Cmdable) error {
+		var keys []string
+		iter := client.Scan(ctx, 0, escapeGlob(c.namespace)+"*", scanBatchSize).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+			if len(keys) == scanBatchSize {
+				if err := unlink(ctx, client, keys); err != nil {
+					return err
+				}
+				keys = keys[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		return unlink(ctx, client, keys)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to clear Redis: %w", err)
 	}
+
+	c.mu.Lock()
+	c.stats.Size = 0
+	c.mu.Unlock()
 	return nil
 }
 
+// consumeScript increments a use counter, setting its expiry on first use,
+// and returns the count and the remaining TTL in milliseconds
+var consumeScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {count, redis.call('PTTL', KEYS[1])}
+`)
+
+func (c *redisCache) Consume(ctx context.Context, key string, ttl time.Duration) (int64, time.Time, error) {
+	var values []int64
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		values, err = consumeScript.Run(ctx, c.client, []string{c.key(useKeys, key)}, ttl.Milliseconds()).Int64Slice()
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, redisError("failed to consume key in Redis", err)
+	}
+
+	// The first use happened as long ago as the TTL has run down
+	count, remaining := values[0], time.Duration(values[1])*time.Millisecond
+	firstUse := time.Now().Add(remaining - ttl)
+
+	return count, firstUse, nil
+}
+
+// unlockScript deletes a lock only if it still holds the owner's value, so an
+// expired lock taken by someone else is left alone
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (c *redisCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, fmt.Errorf("failed to generate lock owner: %w", err)
+	}
+	value := hex.EncodeToString(owner)
+	lockKey := c.key(lockKeys, key)
+
+	var acquired bool
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		acquired, err = c.client.SetNX(ctx, lockKey, value, ttl).Result()
+		return err
+	})
+	if err != nil {
+		return nil, redisError("failed to lock key in Redis", err)
+	}
+	if !acquired {
+		return nil, ErrLocked
+	}
+
+	return func() {
+		// Release even if the request's context is done
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		c.do(ctx, func(ctx context.Context) error {
+			return unlockScript.Run(ctx, c.client, []string{lockKey}, value).Err()
+		})
+	}, nil
+}
+
+// GetStats returns the cache stats. Size is the last count of the cached
+// results, which is refreshed in the background when it is older than
+// sizeRefreshInterval.
 func (c *redisCache) GetStats() Stats {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	degraded := !c.breaker.IsClosed()
+	if !degraded && !c.counting && time.Since(c.countedAt) >= sizeRefreshInterval {
+		c.counting = true
+		c.countedAt = time.Now()
+		go c.countResults()
+	}
 
 	return Stats{
-		Hits:   c.stats.Hits,
-		Misses: c.stats.Misses,
-		Size:   c.stats.Size, // Redis doesn't provide easy size counting
+		Hits:     c.stats.Hits,
+		Misses:   c.stats.Misses,
+		Size:     c.stats.Size,
+		Degraded: degraded,
+	}
+}
+
+func (c *redisCache) Close() error {
+	close(c.done)
+	return c.client.Close()
+}
+
+// countResults counts the cached results in the namespace for Stats.Size.
+// A failed count keeps the previous size.
+func (c *redisCache) countResults() {
+	ctx, cancel := context.WithTimeout(context.Background(), sizeRefreshInterval)
+	defer cancel()
+
+	size, err := c.count(ctx, c.namespace+resultKeys+":")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counting = false
+	if err == nil {
+		c.stats.Size = size
 	}
 }
 
-func (c *redisCache) hashKey(key string) string {
+// count scans the keys starting with prefix, on every master of a cluster
+func (c *redisCache) count(ctx context.Context, prefix string) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+
+	err := c.forEachMaster(ctx, func(ctx context.Context, client redis.Cmdable) error {
+		var n int64
+		iter := client.Scan(ctx, 0, escapeGlob(prefix)+"*", scanBatchSize).Iterator()
+		for iter.Next(ctx) {
+			n++
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return nil
+	})
+	return total, err
+}
+
+// forEachMaster runs fn on every master of a cluster, which each hold part
+// of the keyspace, or on the only master otherwise
+func (c *redisCache) forEachMaster(ctx context.Context, fn func(context.Context, redis.Cmdable) error) error {
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return fn(ctx, client)
+		})
+	}
+	return fn(ctx, c.client)
+}
+
+// key returns the namespaced Redis key of a cache key of the given kind
+func (c *redisCache) key(kind, key string) string {
 	hash := sha256.Sum256([]byte(key))
-	return hex.EncodeToString(hash[:])
+	return c.namespace + kind + ":" + hex.EncodeToString(hash[:])
+}
+
+// unlink deletes keys asynchronously, one command each so keys in different
+// cluster slots can go in one pipeline
+func unlink(ctx context.Context, client redis.Cmdable, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pipe := client.Pipeline()
+	for _, key := range keys {
+		pipe.Unlink(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
-// NewCache creates a new Redis cache
+// escapeGlob escapes the characters SCAN's MATCH pattern treats specially
+func escapeGlob(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(`*?[]\`, r) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// redisError wraps a Redis error, leaving ErrCacheUnavailable bare so callers
+// can tell a bypassed cache from a failed call
+func redisError(msg string, err error) error {
+	if errors.Is(err, ErrCacheUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// NewCache creates the cache selected by Type: "memory" is in-process only,
+// "redis" is shared by all pods, "layered" puts an in-process LRU in front of
+// Redis and "none" stores nothing. An empty type is Redis.
 func NewCache(config Config) (Cache, error) {
-	return NewRedisCache(config)
+	switch config.Type {
+	case "memory":
+		return NewMemoryCache(config), nil
+	case "redis", "":
+		return NewRedisCache(config)
+	case "layered":
+		return NewLayeredCache(config)
+	case "none":
+		return NewNoopCache(), nil
+	default:
+		return nil, fmt.Errorf("unknown cache type %q", config.Type)
+	}
 }
 
-// GenerateCacheKey generates a cache key for a token
-func GenerateCacheKey(token string) string {
-	hash := sha256.Sum256([]byte(token))
+// GenerateCacheKey generates a cache key for a token, optionally scoped by
+// qualifiers such as the policy name so differently judged results don't collide
+func GenerateCacheKey(token string, scope ...string) string {
+	key := token
+	if len(scope) > 0 {
+		key = strings.Join(scope, ":") + ":" + token
+	}
+	hash := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(hash[:])
 } 
\ No newline at end of file
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(Config{MaxMemorySize: 2})
+
+	cache.Set(ctx, "a", &ValidationResult{Success: true}, time.Minute)
+	cache.Set(ctx, "b", &ValidationResult{Success: true}, time.Minute)
+
+	// Using a makes b the least recently used
+	if _, err := cache.Get(ctx, "a"); err != nil {
+		t.Fatalf("Expected hit for a: %v", err)
+	}
+	cache.Set(ctx, "c", &ValidationResult{Success: true}, time.Minute)
+
+	if _, err := cache.Get(ctx, "b"); err == nil {
+		t.Errorf("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := cache.Get(ctx, key); err != nil {
+			t.Errorf("Expected hit for %s: %v", key, err)
+		}
+	}
+
+	stats := cache.GetStats()
+	if stats.Size != 2 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestMemoryCache_Expiry(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(Config{MaxMemorySize: 10})
+
+	cache.Set(ctx, "a", &ValidationResult{Success: true}, -time.Second)
+	if _, err := cache.Get(ctx, "a"); err == nil {
+		t.Errorf("Expected expired entry to miss")
+	}
+	if size := cache.GetStats().Size; size != 0 {
+		t.Errorf("Expected expired entry to be removed, got size %d", size)
+	}
+}
+
+func TestMemoryCache_Lock(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(Config{})
+
+	unlock, err := cache.Lock(ctx, "a", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected lock: %v", err)
+	}
+	if _, err := cache.Lock(ctx, "a", time.Minute); err != ErrLocked {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+
+	unlock()
+	if _, err := cache.Lock(ctx, "a", time.Minute); err != nil {
+		t.Errorf("Expected lock after release: %v", err)
+	}
+}
+
+func TestMemoryCache_ConsumeRemovesExpiredUses(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(Config{}).(*memoryCache)
+
+	for i := 0; i < minUsesSweep-1; i++ {
+		cache.Consume(ctx, fmt.Sprintf("expired-%d", i), time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// The use that fills the map sweeps the expired ones
+	if count, _, err := cache.Consume(ctx, "live", time.Minute); err != nil || count != 1 {
+		t.Fatalf("Expected first use of live, got count %d, error %v", count, err)
+	}
+	if size := len(cache.uses); size != 1 {
+		t.Errorf("Expected only the live use to remain, got %d", size)
+	}
+	if count, _, _ := cache.Consume(ctx, "live", time.Minute); count != 2 {
+		t.Errorf("Expected second use of live, got count %d", count)
+	}
+}
+
+func TestNewCache_WithoutRedis(t *testing.T) {
+	ctx := context.Background()
+
+	memory, err := NewCache(Config{Type: "memory", MaxMemorySize: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	memory.Set(ctx, "a", &ValidationResult{Success: true}, time.Minute)
+	if _, err := memory.Get(ctx, "a"); err != nil {
+		t.Errorf("Expected memory cache hit: %v", err)
+	}
+
+	none, err := NewCache(Config{Type: "none"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	none.Set(ctx, "a", &ValidationResult{Success: true}, time.Minute)
+	if _, err := none.Get(ctx, "a"); err == nil {
+		t.Errorf("Expected no-op cache to miss")
+	}
+	if _, _, err := none.Consume(ctx, "a", time.Minute); err != ErrCacheDisabled {
+		t.Errorf("Expected ErrCacheDisabled, got %v", err)
+	}
+
+	if _, err := NewCache(Config{Type: "disk"}); err == nil {
+		t.Errorf("Expected error for unknown cache type")
+	}
+}
+
+func TestRedisCache_StartsDegradedWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+
+	// Nothing listens on port 1
+	cache, err := NewRedisCache(Config{RedisURL: "redis://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("Expected the cache to start degraded, got error: %v", err)
+	}
+	defer cache.Close()
+
+	if !cache.GetStats().Degraded {
+		t.Errorf("Expected degraded cache")
+	}
+	if _, err := cache.Get(ctx, "a"); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable, got %v", err)
+	}
+	if err := cache.Set(ctx, "a", &ValidationResult{Success: true}, time.Minute); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable, got %v", err)
+	}
+}
+
+func TestNewRedisClient_Errors(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown mode", config: Config{RedisMode: "replicated"}},
+		{name: "invalid URL", config: Config{RedisURL: "http://localhost"}},
+		{name: "missing CA file", config: Config{RedisURL: "rediss://localhost:6379", RedisTLSCAFile: "/nonexistent/ca.pem"}},
+		{name: "CA file without certificates", config: Config{RedisURL: "rediss://localhost:6379", RedisTLSCAFile: notPEM}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRedisClient(tt.config); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestRedisCache_Keys(t *testing.T) {
+	cache, err := NewRedisCache(Config{RedisURL: "redis://127.0.0.1:1", KeyPrefix: "recaptcha-authz:staging"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer cache.Close()
+	c := cache.(*redisCache)
+
+	key := c.key(resultKeys, "token")
+	if !strings.HasPrefix(key, "recaptcha-authz:staging:v1:result:") {
+		t.Errorf("Expected namespaced result key, got %s", key)
+	}
+	if c.key(useKeys, "token") == key || c.key(lockKeys, "token") == key {
+		t.Errorf("Expected key kinds not to collide")
+	}
+
+	if escaped := escapeGlob(`app[1]:*?\`); escaped != `app\[1\]:\*\?\\` {
+		t.Errorf("Unexpected escaped pattern: %s", escaped)
+	}
+}
+
+func TestRedisCache_CountsSizeAtMostEveryInterval(t *testing.T) {
+	cache, err := NewRedisCache(Config{RedisURL: "redis://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer cache.Close()
+	c := cache.(*redisCache)
+
+	// A degraded cache is not counted
+	cache.GetStats()
+	if !c.countedAt.IsZero() {
+		t.Fatalf("Expected no count while degraded")
+	}
+
+	c.breaker.ForceClose()
+	cache.GetStats()
+
+	c.mu.RLock()
+	countedAt := c.countedAt
+	c.mu.RUnlock()
+	if countedAt.IsZero() {
+		t.Fatalf("Expected a count to start")
+	}
+
+	// The count fails, as nothing listens, and keeps the previous size
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.RLock()
+		counting := c.counting
+		c.mu.RUnlock()
+		if !counting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Count did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := cache.GetStats(); stats.Size != 0 {
+		t.Errorf("Expected size 0, got %d", stats.Size)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.counting || !c.countedAt.Equal(countedAt) {
+		t.Errorf("Expected no second count within the interval")
+	}
+}
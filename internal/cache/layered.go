@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// layeredCache checks a bounded in-process LRU (L1) before Redis (L2). Writes
+// go to both, and L1 entries live for at most LocalTTL, so results written or
+// deleted by other pods are seen soon. Use counts and locks are shared
+// between pods, so they live in Redis only.
+type layeredCache struct {
+	config Config
+	local  Cache
+	remote Cache
+}
+
+// NewLayeredCache creates an in-process LRU in front of a Redis cache
+func NewLayeredCache(config Config) (Cache, error) {
+	remote, err := NewRedisCache(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &layeredCache{
+		config: config,
+		local:  NewMemoryCache(config),
+		remote: remote,
+	}, nil
+}
+
+func (c *layeredCache) Get(ctx context.Context, key string) (*ValidationResult, error) {
+	if result, err := c.local.Get(ctx, key); err == nil {
+		return result, nil
+	}
+
+	result, err := c.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep a local copy; its TTL cannot exceed the remote entry's by more
+	// than LocalTTL
+	c.local.Set(ctx, key, result, c.config.LocalTTL)
+	return result, nil
+}
+
+func (c *layeredCache) Set(ctx context.Context, key string, result *ValidationResult, ttl time.Duration) error {
+	if err := c.remote.Set(ctx, key, result, ttl); err != nil {
+		return err
+	}
+	return c.local.Set(ctx, key, result, min(ttl, c.config.LocalTTL))
+}
+
+func (c *layeredCache) Delete(ctx context.Context, key string) error {
+	c.local.Delete(ctx, key)
+	return c.remote.Delete(ctx, key)
+}
+
+func (c *layeredCache) Clear(ctx context.Context) error {
+	c.local.Clear(ctx)
+	return c.remote.Clear(ctx)
+}
+
+func (c *layeredCache) Consume(ctx context.Context, key string, ttl time.Duration) (int64, time.Time, error) {
+	return c.remote.Consume(ctx, key, ttl)
+}
+
+func (c *layeredCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	return c.remote.Lock(ctx, key, ttl)
+}
+
+func (c *layeredCache) Close() error {
+	c.local.Close()
+	return c.remote.Close()
+}
+
+// GetStats reports the stats of each tier. Overall, a hit in either tier is
+// a hit and only L2 misses are misses.
+func (c *layeredCache) GetStats() Stats {
+	l1, l2 := c.local.GetStats(), c.remote.GetStats()
+
+	return Stats{
+		Hits:     l1.Hits + l2.Hits,
+		Misses:   l2.Misses,
+		Size:     l2.Size,
+		Degraded: l2.Degraded,
+		L1:       &l1,
+		L2:       &l2,
+	}
+}
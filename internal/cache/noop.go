@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCacheDisabled is returned by Consume when caching is turned off
+var ErrCacheDisabled = errors.New("cache is disabled")
+
+// noopCache stores nothing, for running with caching turned off. Every Get
+// misses and every Lock is granted, so each request validates on its own.
+type noopCache struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewNoopCache creates a cache that stores nothing
+func NewNoopCache() Cache {
+	return &noopCache{}
+}
+
+func (c *noopCache) Get(ctx context.Context, key string) (*ValidationResult, error) {
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	return nil, ErrCacheMiss
+}
+
+func (c *noopCache) Set(ctx context.Context, key string, result *ValidationResult, ttl time.Duration) error {
+	return nil
+}
+
+func (c *noopCache) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+func (c *noopCache) Clear(ctx context.Context) error {
+	return nil
+}
+
+func (c *noopCache) Consume(ctx context.Context, key string, ttl time.Duration) (int64, time.Time, error) {
+	return 0, time.Time{}, ErrCacheDisabled
+}
+
+func (c *noopCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	return func() {}, nil
+}
+
+func (c *noopCache) Close() error {
+	return nil
+}
+
+func (c *noopCache) GetStats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{Misses: c.stats.Misses}
+}
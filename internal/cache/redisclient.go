@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient builds the Redis client for the configured topology:
+// a single node from RedisURL, a Sentinel-managed master, or a Cluster
+func newRedisClient(config Config) (redis.UniversalClient, error) {
+	tlsConfig, err := redisTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.RedisMode {
+	case "sentinel":
+		opts := &redis.FailoverOptions{
+			MasterName:       config.RedisMasterName,
+			SentinelAddrs:    config.RedisAddrs,
+			SentinelPassword: config.RedisSentinelPassword,
+			Username:         config.RedisUsername,
+			Password:         config.RedisPassword,
+			DB:               config.RedisDB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         config.RedisPoolSize,
+			MinIdleConns:     config.RedisMinIdleConns,
+			ReadTimeout:      config.RedisReadTimeout,
+			WriteTimeout:     config.RedisWriteTimeout,
+		}
+		if config.RedisReadFromReplicas {
+			// Read-only commands go to any node, writes to the master
+			opts.RouteRandomly = true
+			return redis.NewFailoverClusterClient(opts), nil
+		}
+		return redis.NewFailoverClient(opts), nil
+
+	case "cluster":
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        config.RedisAddrs,
+			Username:     config.RedisUsername,
+			Password:     config.RedisPassword,
+			TLSConfig:    tlsConfig,
+			PoolSize:     config.RedisPoolSize,
+			MinIdleConns: config.RedisMinIdleConns,
+			ReadTimeout:  config.RedisReadTimeout,
+			WriteTimeout: config.RedisWriteTimeout,
+			ReadOnly:     config.RedisReadFromReplicas,
+		}), nil
+
+	case "standalone", "":
+		opts, err := redis.ParseURL(config.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+		}
+
+		// Explicit settings override those of the URL
+		if config.RedisUsername != "" {
+			opts.Username = config.RedisUsername
+		}
+		if config.RedisPassword != "" {
+			opts.Password = config.RedisPassword
+		}
+		if tlsConfig != nil {
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName, _, _ = net.SplitHostPort(opts.Addr)
+			}
+			opts.TLSConfig = tlsConfig
+		}
+		if config.RedisPoolSize > 0 {
+			opts.PoolSize = config.RedisPoolSize
+		}
+		if config.RedisMinIdleConns > 0 {
+			opts.MinIdleConns = config.RedisMinIdleConns
+		}
+		if config.RedisReadTimeout > 0 {
+			opts.ReadTimeout = config.RedisReadTimeout
+		}
+		if config.RedisWriteTimeout > 0 {
+			opts.WriteTimeout = config.RedisWriteTimeout
+		}
+		return redis.NewClient(opts), nil
+
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q", config.RedisMode)
+	}
+}
+
+// redisTLSConfig returns the TLS settings for Redis connections, trusting
+// RedisTLSCAFile when set, or nil when TLS is not configured. A rediss://
+// URL enables TLS on its own.
+func redisTLSConfig(config Config) (*tls.Config, error) {
+	if !config.RedisTLS && config.RedisTLSCAFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.RedisTLSServerName,
+	}
+
+	if config.RedisTLSCAFile != "" {
+		ca, err := os.ReadFile(config.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in Redis CA file %s", config.RedisTLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
@@ -2,11 +2,14 @@ package circuitbreaker
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"sync"
 	"time"
 )
 
+// ErrOpen is returned by Execute when the breaker rejects the call
+var ErrOpen = errors.New("circuit breaker is open")
+
 // State represents the circuit breaker state
 type State int
 
@@ -67,7 +70,7 @@ func NewBreaker(config Config) *Breaker {
 // Execute executes a function with circuit breaker protection
 func (b *Breaker) Execute(ctx context.Context, fn func() error) error {
 	if !b.canExecute() {
-		return fmt.Errorf("circuit breaker is open")
+		return ErrOpen
 	}
 
 	b.recordRequest()
@@ -233,10 +236,12 @@ type Stats struct {
 	HalfOpenRequests  int       `json:"half_open_requests"`
 }
 
-// ForceOpen forces the circuit breaker to open state
+// ForceOpen forces the circuit breaker to open state, counting the recovery
+// time from now
 func (b *Breaker) ForceOpen() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.lastFailureTime = time.Now()
 	b.transitionToOpen()
 }
 
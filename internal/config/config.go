@@ -1,26 +1,154 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/prefeitura-rio/app-ext-authz/internal/recaptcha"
 )
 
+// cacheTypes are the supported cache backends
+var cacheTypes = []string{"memory", "redis", "layered", "none"}
+
+// builtinRoutes are the HTTP routes the authorization prefix must stay clear of
+var builtinRoutes = []string{"/health", "/metrics", "/nginx/auth", "/traefik/auth", "/annotations", "/password-leak"}
+
 // Config holds all application configuration
 type Config struct {
+	// reCAPTCHA backend: "enterprise" or the legacy "siteverify" API
+	RecaptchaBackend string
+
 	// reCAPTCHA Enterprise settings
 	RecaptchaProjectID    string
 	RecaptchaSiteKey      string
 	RecaptchaAction       string
 	RecaptchaV3Threshold  float64
 
+	// Legacy siteverify settings
+	RecaptchaSecretKey     string
+	RecaptchaSiteverifyURL string
+
+	// Alternative CAPTCHA providers, each enabled by setting its secret key
+	TurnstileSecretKey   string
+	TurnstileVerifyURL   string
+	TurnstileTokenHeader string
+	HCaptchaSecretKey    string
+	HCaptchaSiteKey      string
+	HCaptchaVerifyURL    string
+	HCaptchaTokenHeader  string
+
+	// Composite provider chain: providers run in order with "fallback",
+	// "any_of" or "all_of" semantics; empty uses the token's own provider
+	ProviderChain     []string
+	ProviderChainMode string
+	ProviderTimeouts  map[string]time.Duration
+
 	// Performance settings
 	GoogleAPITimeoutSeconds int
 	CacheTTLSeconds        int
 	CacheFailedTTLSeconds  int
-	RedisURL               string
+
+	// Cache backend: "memory", "redis", "layered" (in-process in front of
+	// Redis) or "none"
+	CacheType string
+
+	// In-process cache: the maximum TTL of its entries when layered, and
+	// its size
+	CacheLocalTTL      time.Duration
+	CacheLocalMaxItems int
+
+	// Maximum token age measured from its creation time; 0 disables the check
+	TokenMaxAge         time.Duration
+	TokenMaxAgeByAction map[string]time.Duration
+
+	// Single-use tokens: a token may be used SingleUseMaxUses times within
+	// SingleUseWindow of its first use
+	SingleUseTokens  bool
+	SingleUseMaxUses int
+	SingleUseWindow  time.Duration
+
+	// Coalescing of concurrent validations of the same token: "off",
+	// "local" within the process, or "distributed" across pods through a
+	// cache lock
+	CoalesceMode string
+
+	// Redis settings, for the redis and layered caches. Calls time out
+	// after RedisOperationTimeout, and Redis is bypassed for
+	// RedisBreakerRecoveryTime after RedisBreakerFailureThreshold
+	// consecutive failures.
+	RedisURL                     string
+	RedisKeyPrefix               string
+	RedisOperationTimeout        time.Duration
+	RedisBreakerFailureThreshold int
+	RedisBreakerRecoveryTime     time.Duration
+
+	// Redis topology: "standalone" connects to RedisURL, "sentinel" to the
+	// master RedisMasterName through the sentinels in RedisAddrs, and
+	// "cluster" to the cluster nodes in RedisAddrs
+	RedisMode             string
+	RedisAddrs            []string
+	RedisMasterName       string
+	RedisSentinelPassword string
+	RedisDB               int
+
+	// Redis ACL credentials and TLS, overriding those of RedisURL
+	RedisUsername      string
+	RedisPassword      string
+	RedisTLS           bool
+	RedisTLSCAFile     string
+	RedisTLSServerName string
+
+	// Redis connection pool and timeouts; zero keeps the client defaults
+	RedisPoolSize         int
+	RedisMinIdleConns     int
+	RedisReadTimeout      time.Duration
+	RedisWriteTimeout     time.Duration
+	RedisReadFromReplicas bool
+
+	// Allow-lists for the origin of tokens; empty allows any
+	AllowedHostnames       []string
+	AllowedAndroidPackages []string
+	AllowedIOSBundleIDs    []string
+
+	// Additional site keys (web, Android, iOS), selected by name
+	SiteKeys      map[string]SiteKey
+	SiteKeyHeader string
+
+	// Per-route policies, selected by name
+	Policies     map[string]Policy
+	PolicyHeader string
+
+	// Risk analysis reasons denied regardless of score, e.g. AUTOMATION
+	DenyReasons []string
+
+	// Upstream header listing the risk analysis reasons
+	ReasonsHeader string
+
+	// Account Defender: account identifiers read from AccountIDHeader are
+	// sent as an HMAC keyed with AccountIDHMACKey; an empty key disables it
+	AccountIDHeader     string
+	AccountIDHMACKey    string
+	AccountLabelsHeader string
+
+	// Account Defender labels denied regardless of score, e.g. SUSPICIOUS_LOGIN_ACTIVITY
+	DenyAccountLabels []string
+
+	// Score bands for allow, challenge and deny decisions; nil decides by
+	// threshold alone
+	ScoreBands *ScoreBands
+
+	// Shadow mode evaluates and reports decisions but always allows.
+	// ShadowThreshold, when set, is the threshold the reported decision is
+	// made at, so it can be compared with the enforced one.
+	ShadowMode      bool
+	ShadowThreshold *float64
 
 	// Failure handling
 	FailureMode                    string
@@ -34,25 +162,112 @@ type Config struct {
 	OTelServiceName string
 	LogLevel        string
 
+	// Deployment environment, reported in telemetry and namespacing Redis
+	// keys
+	Environment string
+
+	// Window of the in-process score distribution served on /metrics
+	ScoreWindow time.Duration
+
 	// Server settings
-	Port int
+	Port        int
+	GRPCEnabled bool
+	GRPCPort    int
+
+	// Authorization route prefix (Envoy http_service path_prefix)
+	AuthzPathPrefix string
+
+	// Proxies whose X-Forwarded-For entries are trusted when resolving the
+	// client IP; empty uses the connecting peer
+	TrustedProxies []netip.Prefix
+
+	// Header carrying the client's JA3 TLS fingerprint, added by the proxy
+	JA3Header string
+
+	// Bearer key for the assessment annotation endpoint; empty disables it
+	AnnotationAPIKey string
+
+	// Bearer key for the password leak check endpoint; empty disables it
+	PasswordLeakAPIKey string
 
 	// Development
 	MockMode bool
 }
 
+// Policy holds the validation settings applied to a group of routes
+type Policy struct {
+	Actions     []string `json:"actions"`
+	Threshold   float64  `json:"threshold"`
+	FailureMode string   `json:"failure_mode"`
+	DenyReasons []string `json:"deny_reasons"`
+
+	DenyAccountLabels []string `json:"deny_account_labels"`
+
+	Bands *ScoreBands `json:"bands"`
+
+	Shadow          bool     `json:"shadow"`
+	ShadowThreshold *float64 `json:"shadow_threshold"`
+}
+
+// ScoreBands maps scores to allow, step-up challenge and deny decisions.
+// Scores at or above Allow are allowed, scores at or above Challenge are
+// challenged, and lower scores are denied, each with its own HTTP status.
+type ScoreBands struct {
+	Allow     float64 `json:"allow"`
+	Challenge float64 `json:"challenge"`
+
+	AllowStatus     int `json:"allow_status"`     // Default 200
+	ChallengeStatus int `json:"challenge_status"` // Default 200, passing the request upstream
+	DenyStatus      int `json:"deny_status"`      // Default 403
+}
+
+// SiteKey holds a reCAPTCHA key and the expectations for tokens minted with it
+type SiteKey struct {
+	Key       string   `json:"key"`
+	Platform  string   `json:"platform"` // "web", "android" or "ios"
+	Actions   []string `json:"actions"`
+	Threshold float64  `json:"threshold"`
+}
+
+// DefaultSiteKeyName is the name of the site key built from RECAPTCHA_SITE_KEY
+const DefaultSiteKeyName = "default"
+
+// DefaultPolicyName is the name of the policy built from the global settings
+const DefaultPolicyName = "default"
+
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	config := &Config{
 		// Defaults
+		RecaptchaBackend:             "enterprise",
 		RecaptchaProjectID:           "",
 		RecaptchaSiteKey:             "",
 		RecaptchaAction:              "authz",
 		RecaptchaV3Threshold:         0.5,
+		RecaptchaSiteverifyURL:       "https://www.google.com/recaptcha/api/siteverify",
+		TurnstileVerifyURL:           recaptcha.DefaultTurnstileURL,
+		TurnstileTokenHeader:         "X-Turnstile-Token",
+		HCaptchaVerifyURL:            recaptcha.DefaultHCaptchaURL,
+		HCaptchaTokenHeader:          "X-Hcaptcha-Token",
 		GoogleAPITimeoutSeconds:       5,
 		CacheTTLSeconds:               30,
 		CacheFailedTTLSeconds:         300,
+		CacheType:                     "redis",
+		CacheLocalTTL:                 5 * time.Second,
+		CacheLocalMaxItems:            10000,
+		SingleUseMaxUses:              1,
+		CoalesceMode:                  "local",
 		RedisURL:                      "redis://localhost:6379",
+		RedisKeyPrefix:                "recaptcha-authz",
+		RedisOperationTimeout:         250 * time.Millisecond,
+		RedisBreakerFailureThreshold:  5,
+		RedisBreakerRecoveryTime:      10 * time.Second,
+		RedisMode:                     "standalone",
+		SiteKeyHeader:                 "X-Recaptcha-Site-Key",
+		PolicyHeader:                  "X-Recaptcha-Policy",
+		ReasonsHeader:                 "X-Recaptcha-Reasons",
+		AccountIDHeader:               "X-Account-Id",
+		AccountLabelsHeader:           "X-Recaptcha-Account-Labels",
 		FailureMode:                   "fail_open",
 		CircuitBreakerEnabled:         true,
 		CircuitBreakerFailureThreshold: 5,
@@ -60,11 +275,31 @@ func Load() (*Config, error) {
 		HealthCheckIntervalSeconds:    30,
 		OTelServiceName:               "recaptcha-authz",
 		LogLevel:                      "info",
+		Environment:                   "production",
+		ScoreWindow:                   5 * time.Minute,
 		Port:                          8080,
+		GRPCEnabled:                   true,
+		GRPCPort:                      9090,
+		AuthzPathPrefix:               "/authz",
+		JA3Header:                     "X-JA3-Fingerprint",
+	}
+
+	if backend := os.Getenv("RECAPTCHA_BACKEND"); backend != "" {
+		if backend == "enterprise" || backend == "siteverify" {
+			config.RecaptchaBackend = backend
+		} else {
+			return nil, fmt.Errorf("RECAPTCHA_BACKEND must be 'enterprise' or 'siteverify'")
+		}
 	}
 
 	// Required settings
-	if projectID := os.Getenv("RECAPTCHA_PROJECT_ID"); projectID != "" {
+	if config.RecaptchaBackend == "siteverify" {
+		if secretKey := os.Getenv("RECAPTCHA_SECRET_KEY"); secretKey != "" {
+			config.RecaptchaSecretKey = secretKey
+		} else {
+			return nil, fmt.Errorf("RECAPTCHA_SECRET_KEY is required for the siteverify backend")
+		}
+	} else if projectID := os.Getenv("RECAPTCHA_PROJECT_ID"); projectID != "" {
 		config.RecaptchaProjectID = projectID
 	} else {
 		return nil, fmt.Errorf("RECAPTCHA_PROJECT_ID is required")
@@ -81,6 +316,10 @@ func Load() (*Config, error) {
 		config.RecaptchaAction = action
 	}
 
+	if siteverifyURL := os.Getenv("RECAPTCHA_SITEVERIFY_URL"); siteverifyURL != "" {
+		config.RecaptchaSiteverifyURL = siteverifyURL
+	}
+
 	if threshold := os.Getenv("RECAPTCHA_V3_THRESHOLD"); threshold != "" {
 		if t, err := strconv.ParseFloat(threshold, 64); err == nil && t >= 0.0 && t <= 1.0 {
 			config.RecaptchaV3Threshold = t
@@ -89,6 +328,58 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if secretKey := os.Getenv("TURNSTILE_SECRET_KEY"); secretKey != "" {
+		config.TurnstileSecretKey = secretKey
+	}
+
+	if verifyURL := os.Getenv("TURNSTILE_VERIFY_URL"); verifyURL != "" {
+		config.TurnstileVerifyURL = verifyURL
+	}
+
+	if header := os.Getenv("TURNSTILE_TOKEN_HEADER"); header != "" {
+		config.TurnstileTokenHeader = header
+	}
+
+	if secretKey := os.Getenv("HCAPTCHA_SECRET_KEY"); secretKey != "" {
+		config.HCaptchaSecretKey = secretKey
+	}
+
+	if siteKey := os.Getenv("HCAPTCHA_SITE_KEY"); siteKey != "" {
+		config.HCaptchaSiteKey = siteKey
+	}
+
+	if verifyURL := os.Getenv("HCAPTCHA_VERIFY_URL"); verifyURL != "" {
+		config.HCaptchaVerifyURL = verifyURL
+	}
+
+	if header := os.Getenv("HCAPTCHA_TOKEN_HEADER"); header != "" {
+		config.HCaptchaTokenHeader = header
+	}
+
+	if chain := os.Getenv("PROVIDER_CHAIN"); chain != "" {
+		config.ProviderChain = splitList(chain)
+	}
+
+	if mode := os.Getenv("PROVIDER_CHAIN_MODE"); mode != "" {
+		if mode == "fallback" || mode == "any_of" || mode == "all_of" {
+			config.ProviderChainMode = mode
+		} else {
+			return nil, fmt.Errorf("PROVIDER_CHAIN_MODE must be 'fallback', 'any_of' or 'all_of'")
+		}
+	}
+
+	if timeouts := os.Getenv("PROVIDER_TIMEOUTS"); timeouts != "" {
+		config.ProviderTimeouts = make(map[string]time.Duration)
+		for _, item := range splitList(timeouts) {
+			provider, seconds, found := strings.Cut(item, "=")
+			t, err := strconv.Atoi(strings.TrimSpace(seconds))
+			if !found || err != nil || t <= 0 {
+				return nil, fmt.Errorf("PROVIDER_TIMEOUTS must be a list of provider=seconds")
+			}
+			config.ProviderTimeouts[strings.TrimSpace(provider)] = time.Duration(t) * time.Second
+		}
+	}
+
 	if timeout := os.Getenv("GOOGLE_API_TIMEOUT_SECONDS"); timeout != "" {
 		if t, err := strconv.Atoi(timeout); err == nil && t > 0 {
 			config.GoogleAPITimeoutSeconds = t
@@ -113,10 +404,178 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if cacheType := os.Getenv("CACHE_TYPE"); cacheType != "" {
+		if slices.Contains(cacheTypes, cacheType) {
+			config.CacheType = cacheType
+		} else {
+			return nil, fmt.Errorf("CACHE_TYPE must be 'memory', 'redis', 'layered' or 'none'")
+		}
+	}
+
+	if localTTL := os.Getenv("CACHE_LOCAL_TTL_SECONDS"); localTTL != "" {
+		if t, err := strconv.Atoi(localTTL); err == nil && t > 0 {
+			config.CacheLocalTTL = time.Duration(t) * time.Second
+		} else {
+			return nil, fmt.Errorf("CACHE_LOCAL_TTL_SECONDS must be a positive integer")
+		}
+	}
+
+	if maxItems := os.Getenv("CACHE_LOCAL_MAX_ITEMS"); maxItems != "" {
+		if n, err := strconv.Atoi(maxItems); err == nil && n > 0 {
+			config.CacheLocalMaxItems = n
+		} else {
+			return nil, fmt.Errorf("CACHE_LOCAL_MAX_ITEMS must be a positive integer")
+		}
+	}
+
+	if maxAge := os.Getenv("TOKEN_MAX_AGE_SECONDS"); maxAge != "" {
+		if t, err := strconv.Atoi(maxAge); err == nil && t >= 0 {
+			config.TokenMaxAge = time.Duration(t) * time.Second
+		} else {
+			return nil, fmt.Errorf("TOKEN_MAX_AGE_SECONDS must be a non-negative integer")
+		}
+	}
+
+	if byAction := os.Getenv("TOKEN_MAX_AGE_BY_ACTION"); byAction != "" {
+		config.TokenMaxAgeByAction = make(map[string]time.Duration)
+		for _, item := range splitList(byAction) {
+			action, seconds, found := strings.Cut(item, "=")
+			t, err := strconv.Atoi(strings.TrimSpace(seconds))
+			if !found || err != nil || t < 0 {
+				return nil, fmt.Errorf("TOKEN_MAX_AGE_BY_ACTION must be a list of action=seconds")
+			}
+			config.TokenMaxAgeByAction[strings.TrimSpace(action)] = time.Duration(t) * time.Second
+		}
+	}
+
+	if enabled := os.Getenv("SINGLE_USE_TOKENS"); enabled != "" {
+		config.SingleUseTokens = strings.ToLower(enabled) == "true"
+	}
+
+	if maxUses := os.Getenv("SINGLE_USE_MAX_USES"); maxUses != "" {
+		if n, err := strconv.Atoi(maxUses); err == nil && n > 0 {
+			config.SingleUseMaxUses = n
+		} else {
+			return nil, fmt.Errorf("SINGLE_USE_MAX_USES must be a positive integer")
+		}
+	}
+
+	if window := os.Getenv("SINGLE_USE_WINDOW_SECONDS"); window != "" {
+		if t, err := strconv.Atoi(window); err == nil && t >= 0 {
+			config.SingleUseWindow = time.Duration(t) * time.Second
+		} else {
+			return nil, fmt.Errorf("SINGLE_USE_WINDOW_SECONDS must be a non-negative integer")
+		}
+	}
+
+	if mode := os.Getenv("COALESCE_MODE"); mode != "" {
+		if mode == "off" || mode == "local" || mode == "distributed" {
+			config.CoalesceMode = mode
+		} else {
+			return nil, fmt.Errorf("COALESCE_MODE must be 'off', 'local' or 'distributed'")
+		}
+	}
+
 	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
 		config.RedisURL = redisURL
 	}
 
+	if prefix := os.Getenv("REDIS_KEY_PREFIX"); prefix != "" {
+		config.RedisKeyPrefix = prefix
+	}
+
+	if mode := os.Getenv("REDIS_MODE"); mode != "" {
+		if mode == "standalone" || mode == "sentinel" || mode == "cluster" {
+			config.RedisMode = mode
+		} else {
+			return nil, fmt.Errorf("REDIS_MODE must be 'standalone', 'sentinel' or 'cluster'")
+		}
+	}
+
+	if addrs := os.Getenv("REDIS_ADDRS"); addrs != "" {
+		config.RedisAddrs = splitList(addrs)
+	}
+
+	config.RedisMasterName = os.Getenv("REDIS_MASTER_NAME")
+	config.RedisSentinelPassword = os.Getenv("REDIS_SENTINEL_PASSWORD")
+	config.RedisUsername = os.Getenv("REDIS_USERNAME")
+	config.RedisPassword = os.Getenv("REDIS_PASSWORD")
+
+	if db := os.Getenv("REDIS_DB"); db != "" {
+		if n, err := strconv.Atoi(db); err == nil && n >= 0 {
+			config.RedisDB = n
+		} else {
+			return nil, fmt.Errorf("REDIS_DB must be a non-negative integer")
+		}
+	}
+
+	if enabled := os.Getenv("REDIS_TLS"); enabled != "" {
+		config.RedisTLS = strings.ToLower(enabled) == "true"
+	}
+
+	config.RedisTLSCAFile = os.Getenv("REDIS_TLS_CA_FILE")
+	config.RedisTLSServerName = os.Getenv("REDIS_TLS_SERVER_NAME")
+
+	if poolSize := os.Getenv("REDIS_POOL_SIZE"); poolSize != "" {
+		if n, err := strconv.Atoi(poolSize); err == nil && n > 0 {
+			config.RedisPoolSize = n
+		} else {
+			return nil, fmt.Errorf("REDIS_POOL_SIZE must be a positive integer")
+		}
+	}
+
+	if minIdle := os.Getenv("REDIS_MIN_IDLE_CONNS"); minIdle != "" {
+		if n, err := strconv.Atoi(minIdle); err == nil && n >= 0 {
+			config.RedisMinIdleConns = n
+		} else {
+			return nil, fmt.Errorf("REDIS_MIN_IDLE_CONNS must be a non-negative integer")
+		}
+	}
+
+	if timeout := os.Getenv("REDIS_READ_TIMEOUT_MS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t > 0 {
+			config.RedisReadTimeout = time.Duration(t) * time.Millisecond
+		} else {
+			return nil, fmt.Errorf("REDIS_READ_TIMEOUT_MS must be a positive integer")
+		}
+	}
+
+	if timeout := os.Getenv("REDIS_WRITE_TIMEOUT_MS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t > 0 {
+			config.RedisWriteTimeout = time.Duration(t) * time.Millisecond
+		} else {
+			return nil, fmt.Errorf("REDIS_WRITE_TIMEOUT_MS must be a positive integer")
+		}
+	}
+
+	if enabled := os.Getenv("REDIS_READ_FROM_REPLICAS"); enabled != "" {
+		config.RedisReadFromReplicas = strings.ToLower(enabled) == "true"
+	}
+
+	if timeout := os.Getenv("REDIS_OPERATION_TIMEOUT_MS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t > 0 {
+			config.RedisOperationTimeout = time.Duration(t) * time.Millisecond
+		} else {
+			return nil, fmt.Errorf("REDIS_OPERATION_TIMEOUT_MS must be a positive integer")
+		}
+	}
+
+	if threshold := os.Getenv("REDIS_BREAKER_FAILURE_THRESHOLD"); threshold != "" {
+		if t, err := strconv.Atoi(threshold); err == nil && t > 0 {
+			config.RedisBreakerFailureThreshold = t
+		} else {
+			return nil, fmt.Errorf("REDIS_BREAKER_FAILURE_THRESHOLD must be a positive integer")
+		}
+	}
+
+	if recoveryTime := os.Getenv("REDIS_BREAKER_RECOVERY_TIME_SECONDS"); recoveryTime != "" {
+		if t, err := strconv.Atoi(recoveryTime); err == nil && t > 0 {
+			config.RedisBreakerRecoveryTime = time.Duration(t) * time.Second
+		} else {
+			return nil, fmt.Errorf("REDIS_BREAKER_RECOVERY_TIME_SECONDS must be a positive integer")
+		}
+	}
+
 	if mode := os.Getenv("FAILURE_MODE"); mode != "" {
 		if mode == "fail_open" || mode == "fail_closed" {
 			config.FailureMode = mode
@@ -125,6 +584,87 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if hostnames := os.Getenv("ALLOWED_HOSTNAMES"); hostnames != "" {
+		config.AllowedHostnames = splitList(hostnames)
+	}
+
+	if packages := os.Getenv("ALLOWED_ANDROID_PACKAGES"); packages != "" {
+		config.AllowedAndroidPackages = splitList(packages)
+	}
+
+	if bundleIDs := os.Getenv("ALLOWED_IOS_BUNDLE_IDS"); bundleIDs != "" {
+		config.AllowedIOSBundleIDs = splitList(bundleIDs)
+	}
+
+	if reasons := os.Getenv("DENY_REASONS"); reasons != "" {
+		config.DenyReasons = splitList(reasons)
+	}
+
+	if header := os.Getenv("REASONS_HEADER"); header != "" {
+		config.ReasonsHeader = header
+	}
+
+	if header := os.Getenv("ACCOUNT_ID_HEADER"); header != "" {
+		config.AccountIDHeader = header
+	}
+
+	if key := os.Getenv("ACCOUNT_ID_HMAC_KEY"); key != "" {
+		config.AccountIDHMACKey = key
+	}
+
+	if header := os.Getenv("ACCOUNT_LABELS_HEADER"); header != "" {
+		config.AccountLabelsHeader = header
+	}
+
+	if labels := os.Getenv("DENY_ACCOUNT_LABELS"); labels != "" {
+		config.DenyAccountLabels = splitList(labels)
+	}
+
+	if shadow := os.Getenv("SHADOW_MODE"); shadow != "" {
+		config.ShadowMode = strings.ToLower(shadow) == "true"
+	}
+
+	if threshold := os.Getenv("SHADOW_THRESHOLD"); threshold != "" {
+		if t, err := strconv.ParseFloat(threshold, 64); err == nil && t >= 0.0 && t <= 1.0 {
+			config.ShadowThreshold = &t
+		} else {
+			return nil, fmt.Errorf("SHADOW_THRESHOLD must be between 0.0 and 1.0")
+		}
+	}
+
+	if bands := os.Getenv("SCORE_BANDS"); bands != "" {
+		var parsed ScoreBands
+		if err := json.Unmarshal([]byte(bands), &parsed); err != nil {
+			return nil, fmt.Errorf("SCORE_BANDS is invalid: %w", err)
+		}
+		parsed.setDefaultStatuses()
+		config.ScoreBands = &parsed
+	}
+
+	if siteKeys := os.Getenv("RECAPTCHA_SITE_KEYS"); siteKeys != "" {
+		parsed, err := parseSiteKeys(siteKeys, config)
+		if err != nil {
+			return nil, fmt.Errorf("RECAPTCHA_SITE_KEYS is invalid: %w", err)
+		}
+		config.SiteKeys = parsed
+	}
+
+	if header := os.Getenv("SITE_KEY_HEADER"); header != "" {
+		config.SiteKeyHeader = header
+	}
+
+	if policies := os.Getenv("RECAPTCHA_POLICIES"); policies != "" {
+		parsed, err := parsePolicies(policies, config)
+		if err != nil {
+			return nil, fmt.Errorf("RECAPTCHA_POLICIES is invalid: %w", err)
+		}
+		config.Policies = parsed
+	}
+
+	if header := os.Getenv("POLICY_HEADER"); header != "" {
+		config.PolicyHeader = header
+	}
+
 	if enabled := os.Getenv("CIRCUIT_BREAKER_ENABLED"); enabled != "" {
 		config.CircuitBreakerEnabled = strings.ToLower(enabled) == "true"
 	}
@@ -166,6 +706,18 @@ func Load() (*Config, error) {
 		config.LogLevel = strings.ToLower(logLevel)
 	}
 
+	if environment := os.Getenv("ENVIRONMENT"); environment != "" {
+		config.Environment = environment
+	}
+
+	if window := os.Getenv("SCORE_WINDOW_SECONDS"); window != "" {
+		if t, err := strconv.Atoi(window); err == nil && t > 0 {
+			config.ScoreWindow = time.Duration(t) * time.Second
+		} else {
+			return nil, fmt.Errorf("SCORE_WINDOW_SECONDS must be a positive integer")
+		}
+	}
+
 	// Server settings
 	if port := os.Getenv("PORT"); port != "" {
 		if p, err := strconv.Atoi(port); err == nil && p > 0 && p < 65536 {
@@ -175,16 +727,328 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if apiKey := os.Getenv("ANNOTATION_API_KEY"); apiKey != "" {
+		config.AnnotationAPIKey = apiKey
+	}
+
+	if apiKey := os.Getenv("PASSWORD_LEAK_API_KEY"); apiKey != "" {
+		config.PasswordLeakAPIKey = apiKey
+	}
+
+	if enabled := os.Getenv("GRPC_ENABLED"); enabled != "" {
+		config.GRPCEnabled = strings.ToLower(enabled) == "true"
+	}
+
+	if port := os.Getenv("GRPC_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil && p > 0 && p < 65536 {
+			config.GRPCPort = p
+		} else {
+			return nil, fmt.Errorf("GRPC_PORT must be a valid port number (1-65535)")
+		}
+	}
+
+	if prefix := os.Getenv("AUTHZ_PATH_PREFIX"); prefix != "" {
+		config.AuthzPathPrefix = strings.TrimSuffix(prefix, "/")
+	}
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		parsed, err := parsePrefixes(proxies)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES is invalid: %w", err)
+		}
+		config.TrustedProxies = parsed
+	}
+
+	if header := os.Getenv("JA3_HEADER"); header != "" {
+		config.JA3Header = header
+	}
+
 	// Development mode
 	config.MockMode = strings.ToLower(os.Getenv("MOCK_MODE")) == "true"
 
 	return config, nil
 }
 
+// Providers returns the enabled CAPTCHA providers, reCAPTCHA first
+func (c *Config) Providers() []string {
+	providers := []string{"recaptcha"}
+	if c.TurnstileSecretKey != "" {
+		providers = append(providers, "turnstile")
+	}
+	if c.HCaptchaSecretKey != "" {
+		providers = append(providers, "hcaptcha")
+	}
+	return providers
+}
+
+// UsesRedis reports whether the cache backend needs Redis
+func (c *Config) UsesRedis() bool {
+	return c.CacheType == "redis" || c.CacheType == "layered"
+}
+
+// RedisNamespace returns the prefix of Redis keys: the key prefix and the
+// environment, so environments sharing a Redis keep apart
+func (c *Config) RedisNamespace() string {
+	var parts []string
+	for _, part := range []string{c.RedisKeyPrefix, c.Environment} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// ProviderTimeout returns the API timeout for a provider
+func (c *Config) ProviderTimeout(provider string) time.Duration {
+	if timeout, ok := c.ProviderTimeouts[provider]; ok {
+		return timeout
+	}
+	return time.Duration(c.GoogleAPITimeoutSeconds) * time.Second
+}
+
+// MaxTokenAge returns the maximum token age for an action, or 0 if unlimited
+func (c *Config) MaxTokenAge(action string) time.Duration {
+	if maxAge, ok := c.TokenMaxAgeByAction[action]; ok {
+		return maxAge
+	}
+	return c.TokenMaxAge
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// redactURL hides the password of a URL, for logging
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Redacted()
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs and bare addresses
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseSiteKeys parses a JSON object of named site keys. Fields left out of
+// a key inherit the global action and threshold; the platform defaults to web.
+func parseSiteKeys(data string, defaults *Config) (map[string]SiteKey, error) {
+	var raw map[string]struct {
+		Key       string   `json:"key"`
+		Platform  string   `json:"platform"`
+		Actions   []string `json:"actions"`
+		Threshold *float64 `json:"threshold"`
+	}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+
+	siteKeys := make(map[string]SiteKey, len(raw))
+	for name, k := range raw {
+		siteKey := SiteKey{
+			Key:       k.Key,
+			Platform:  strings.ToLower(k.Platform),
+			Actions:   k.Actions,
+			Threshold: defaults.RecaptchaV3Threshold,
+		}
+		if siteKey.Platform == "" {
+			siteKey.Platform = "web"
+		}
+		if len(siteKey.Actions) == 0 {
+			siteKey.Actions = []string{defaults.RecaptchaAction}
+		}
+		if k.Threshold != nil {
+			siteKey.Threshold = *k.Threshold
+		}
+		siteKeys[name] = siteKey
+	}
+
+	return siteKeys, nil
+}
+
+// ResolveSiteKey returns the site key matching name, either by its name or by
+// its key value, or the default site key built from the global settings
+func (c *Config) ResolveSiteKey(name string) (string, SiteKey) {
+	if name != "" {
+		if siteKey, ok := c.SiteKeys[name]; ok {
+			return name, siteKey
+		}
+		for keyName, siteKey := range c.SiteKeys {
+			if siteKey.Key == name {
+				return keyName, siteKey
+			}
+		}
+	}
+	return DefaultSiteKeyName, SiteKey{
+		Key:       c.RecaptchaSiteKey,
+		Platform:  "web",
+		Actions:   []string{c.RecaptchaAction},
+		Threshold: c.RecaptchaV3Threshold,
+	}
+}
+
+// parsePolicies parses a JSON object of named policies. Fields left out of a
+// policy inherit the global action, threshold and failure mode.
+func parsePolicies(data string, defaults *Config) (map[string]Policy, error) {
+	var raw map[string]struct {
+		Actions     []string `json:"actions"`
+		Threshold   *float64 `json:"threshold"`
+		FailureMode string   `json:"failure_mode"`
+		DenyReasons []string `json:"deny_reasons"`
+
+		DenyAccountLabels []string `json:"deny_account_labels"`
+
+		Bands *ScoreBands `json:"bands"`
+
+		Shadow          *bool    `json:"shadow"`
+		ShadowThreshold *float64 `json:"shadow_threshold"`
+	}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+
+	policies := make(map[string]Policy, len(raw))
+	for name, p := range raw {
+		policy := Policy{
+			Actions:     p.Actions,
+			Threshold:   defaults.RecaptchaV3Threshold,
+			FailureMode: p.FailureMode,
+			DenyReasons: p.DenyReasons,
+
+			DenyAccountLabels: p.DenyAccountLabels,
+
+			Bands: p.Bands,
+
+			Shadow:          defaults.ShadowMode,
+			ShadowThreshold: p.ShadowThreshold,
+		}
+		if len(policy.Actions) == 0 {
+			policy.Actions = []string{defaults.RecaptchaAction}
+		}
+		if p.Threshold != nil {
+			policy.Threshold = *p.Threshold
+		}
+		if policy.FailureMode == "" {
+			policy.FailureMode = defaults.FailureMode
+		}
+		if p.DenyReasons == nil {
+			policy.DenyReasons = defaults.DenyReasons
+		}
+		if p.DenyAccountLabels == nil {
+			policy.DenyAccountLabels = defaults.DenyAccountLabels
+		}
+		if policy.Bands == nil {
+			policy.Bands = defaults.ScoreBands
+		} else {
+			policy.Bands.setDefaultStatuses()
+		}
+		if p.Shadow != nil {
+			policy.Shadow = *p.Shadow
+		}
+		if p.ShadowThreshold == nil {
+			policy.ShadowThreshold = defaults.ShadowThreshold
+		}
+		policies[name] = policy
+	}
+
+	return policies, nil
+}
+
+// ResolvePolicy returns the named policy, or the default policy built from the
+// global settings when the name is empty or unknown
+func (c *Config) ResolvePolicy(name string) (string, Policy) {
+	if policy, ok := c.Policies[name]; ok && name != "" {
+		return name, policy
+	}
+	return DefaultPolicyName, Policy{
+		Actions:     []string{c.RecaptchaAction},
+		Threshold:   c.RecaptchaV3Threshold,
+		FailureMode: c.FailureMode,
+		DenyReasons: c.DenyReasons,
+
+		DenyAccountLabels: c.DenyAccountLabels,
+
+		Bands: c.ScoreBands,
+
+		Shadow:          c.ShadowMode,
+		ShadowThreshold: c.ShadowThreshold,
+	}
+}
+
+// setDefaultStatuses fills in the HTTP statuses left out of the bands
+func (b *ScoreBands) setDefaultStatuses() {
+	if b.AllowStatus == 0 {
+		b.AllowStatus = 200
+	}
+	if b.ChallengeStatus == 0 {
+		b.ChallengeStatus = 200
+	}
+	if b.DenyStatus == 0 {
+		b.DenyStatus = 403
+	}
+}
+
+// validate checks the band boundaries and statuses
+func (b *ScoreBands) validate() error {
+	if b.Allow < 0.0 || b.Allow > 1.0 {
+		return fmt.Errorf("allow score must be between 0.0 and 1.0")
+	}
+	if b.Challenge < 0.0 || b.Challenge > b.Allow {
+		return fmt.Errorf("challenge score must be between 0.0 and the allow score")
+	}
+	if b.AllowStatus < 200 || b.AllowStatus > 299 {
+		return fmt.Errorf("allow status must be 2xx")
+	}
+	if b.ChallengeStatus < 200 || b.ChallengeStatus > 599 {
+		return fmt.Errorf("challenge status must be between 200 and 599")
+	}
+	if b.DenyStatus < 400 || b.DenyStatus > 599 {
+		return fmt.Errorf("deny status must be 4xx or 5xx")
+	}
+	return nil
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
-	if c.RecaptchaProjectID == "" {
-		return fmt.Errorf("recaptcha project ID is required")
+	switch c.RecaptchaBackend {
+	case "", "enterprise":
+		if c.RecaptchaProjectID == "" {
+			return fmt.Errorf("recaptcha project ID is required")
+		}
+	case "siteverify":
+		if c.RecaptchaSecretKey == "" {
+			return fmt.Errorf("recaptcha secret key is required for the siteverify backend")
+		}
+		if c.RecaptchaSiteverifyURL == "" {
+			return fmt.Errorf("recaptcha siteverify URL is required for the siteverify backend")
+		}
+	default:
+		return fmt.Errorf("recaptcha backend must be 'enterprise' or 'siteverify'")
 	}
 
 	if c.RecaptchaSiteKey == "" {
@@ -195,6 +1059,35 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("recaptcha v3 threshold must be between 0.0 and 1.0")
 	}
 
+	if c.AccountIDHMACKey != "" {
+		if c.RecaptchaBackend == "siteverify" {
+			return fmt.Errorf("account defender requires the enterprise backend")
+		}
+		if c.AccountIDHeader == "" {
+			return fmt.Errorf("account ID header is required for account defender")
+		}
+	}
+
+	if c.TurnstileSecretKey != "" && (c.TurnstileVerifyURL == "" || c.TurnstileTokenHeader == "") {
+		return fmt.Errorf("turnstile verify URL and token header are required")
+	}
+
+	if c.HCaptchaSecretKey != "" && (c.HCaptchaVerifyURL == "" || c.HCaptchaTokenHeader == "") {
+		return fmt.Errorf("hcaptcha verify URL and token header are required")
+	}
+
+	if len(c.ProviderChain) > 0 {
+		if c.ProviderChainMode != "fallback" && c.ProviderChainMode != "any_of" && c.ProviderChainMode != "all_of" {
+			return fmt.Errorf("provider chain mode must be 'fallback', 'any_of' or 'all_of'")
+		}
+		enabled := c.Providers()
+		for _, provider := range c.ProviderChain {
+			if !slices.Contains(enabled, provider) {
+				return fmt.Errorf("provider chain member %q is not an enabled provider", provider)
+			}
+		}
+	}
+
 	if c.GoogleAPITimeoutSeconds <= 0 {
 		return fmt.Errorf("google API timeout must be positive")
 	}
@@ -207,14 +1100,121 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("failed cache TTL must be positive")
 	}
 
-	if c.RedisURL == "" {
-		return fmt.Errorf("redis URL is required")
+	if !slices.Contains(cacheTypes, c.CacheType) {
+		return fmt.Errorf("cache type must be 'memory', 'redis', 'layered' or 'none'")
+	}
+
+	if c.CacheType == "layered" && c.CacheLocalTTL <= 0 {
+		return fmt.Errorf("local cache TTL must be positive")
+	}
+
+	if (c.CacheType == "memory" || c.CacheType == "layered") && c.CacheLocalMaxItems <= 0 {
+		return fmt.Errorf("local cache max items must be positive")
+	}
+
+	if c.SingleUseTokens {
+		if c.CacheType == "none" {
+			return fmt.Errorf("single-use tokens require a cache")
+		}
+		if c.SingleUseMaxUses <= 0 {
+			return fmt.Errorf("single-use max uses must be positive")
+		}
+		if c.SingleUseWindow < 0 {
+			return fmt.Errorf("single-use window must not be negative")
+		}
+	}
+
+	if c.ScoreWindow <= 0 {
+		return fmt.Errorf("score window must be positive")
+	}
+
+	if c.CoalesceMode != "off" && c.CoalesceMode != "local" && c.CoalesceMode != "distributed" {
+		return fmt.Errorf("coalesce mode must be 'off', 'local' or 'distributed'")
+	}
+
+	// The lock must be visible to every pod, which only Redis is
+	if c.CoalesceMode == "distributed" && !c.UsesRedis() {
+		return fmt.Errorf("distributed coalescing requires the redis or layered cache")
+	}
+
+	if c.UsesRedis() {
+		switch c.RedisMode {
+		case "standalone":
+			if c.RedisURL == "" {
+				return fmt.Errorf("redis URL is required")
+			}
+		case "sentinel":
+			if len(c.RedisAddrs) == 0 || c.RedisMasterName == "" {
+				return fmt.Errorf("redis sentinel mode requires sentinel addresses and a master name")
+			}
+		case "cluster":
+			if len(c.RedisAddrs) == 0 {
+				return fmt.Errorf("redis cluster mode requires node addresses")
+			}
+		default:
+			return fmt.Errorf("redis mode must be 'standalone', 'sentinel' or 'cluster'")
+		}
+		if c.RedisTLSCAFile != "" {
+			if _, err := os.Stat(c.RedisTLSCAFile); err != nil {
+				return fmt.Errorf("redis CA file: %w", err)
+			}
+		}
+		if c.RedisOperationTimeout <= 0 {
+			return fmt.Errorf("redis operation timeout must be positive")
+		}
+		if c.RedisBreakerFailureThreshold <= 0 {
+			return fmt.Errorf("redis breaker failure threshold must be positive")
+		}
+		if c.RedisBreakerRecoveryTime <= 0 {
+			return fmt.Errorf("redis breaker recovery time must be positive")
+		}
 	}
 
 	if c.FailureMode != "fail_open" && c.FailureMode != "fail_closed" {
 		return fmt.Errorf("failure mode must be 'fail_open' or 'fail_closed'")
 	}
 
+	if c.ScoreBands != nil {
+		if err := c.ScoreBands.validate(); err != nil {
+			return fmt.Errorf("score bands: %w", err)
+		}
+	}
+
+	for name, siteKey := range c.SiteKeys {
+		if siteKey.Key == "" {
+			return fmt.Errorf("site key %q must have a key", name)
+		}
+		if siteKey.Platform != "web" && siteKey.Platform != "android" && siteKey.Platform != "ios" {
+			return fmt.Errorf("site key %q platform must be 'web', 'android' or 'ios'", name)
+		}
+		if len(siteKey.Actions) == 0 {
+			return fmt.Errorf("site key %q must have at least one action", name)
+		}
+		if siteKey.Threshold < 0.0 || siteKey.Threshold > 1.0 {
+			return fmt.Errorf("site key %q threshold must be between 0.0 and 1.0", name)
+		}
+	}
+
+	for name, policy := range c.Policies {
+		if len(policy.Actions) == 0 {
+			return fmt.Errorf("policy %q must have at least one action", name)
+		}
+		if policy.Threshold < 0.0 || policy.Threshold > 1.0 {
+			return fmt.Errorf("policy %q threshold must be between 0.0 and 1.0", name)
+		}
+		if policy.FailureMode != "fail_open" && policy.FailureMode != "fail_closed" {
+			return fmt.Errorf("policy %q failure mode must be 'fail_open' or 'fail_closed'", name)
+		}
+		if policy.ShadowThreshold != nil && (*policy.ShadowThreshold < 0.0 || *policy.ShadowThreshold > 1.0) {
+			return fmt.Errorf("policy %q shadow threshold must be between 0.0 and 1.0", name)
+		}
+		if policy.Bands != nil {
+			if err := policy.Bands.validate(); err != nil {
+				return fmt.Errorf("policy %q score bands: %w", name, err)
+			}
+		}
+	}
+
 	if c.CircuitBreakerFailureThreshold <= 0 {
 		return fmt.Errorf("circuit breaker failure threshold must be positive")
 	}
@@ -231,23 +1231,58 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("port must be between 1 and 65535")
 	}
 
+	if c.GRPCEnabled {
+		if c.GRPCPort <= 0 || c.GRPCPort > 65535 {
+			return fmt.Errorf("gRPC port must be between 1 and 65535")
+		}
+		if c.GRPCPort == c.Port {
+			return fmt.Errorf("gRPC port must differ from HTTP port")
+		}
+	}
+
+	if !strings.HasPrefix(c.AuthzPathPrefix, "/") || c.AuthzPathPrefix == "/" {
+		return fmt.Errorf("authz path prefix must start with '/' and must not be the root path")
+	}
+
+	// The prefix must not be a built-in route, nor lie above or below one
+	for _, route := range builtinRoutes {
+		if strings.HasPrefix(c.AuthzPathPrefix+"/", route+"/") || strings.HasPrefix(route+"/", c.AuthzPathPrefix+"/") {
+			return fmt.Errorf("authz path prefix %s collides with the built-in route %s", c.AuthzPathPrefix, route)
+		}
+	}
+
 	return nil
 }
 
 // String returns a string representation of the config (without sensitive data)
 func (c *Config) String() string {
 	return fmt.Sprintf(
-		"Config{ProjectID: %s, SiteKey: %s, Action: %s, V3Threshold: %.2f, Timeout: %ds, CacheTTL: %ds, RedisURL: %s, FailureMode: %s, CircuitBreaker: %t, Port: %d, MockMode: %t}",
+		"Config{Backend: %s, ProjectID: %s, SiteKey: %s, Action: %s, V3Threshold: %.2f, SiteKeys: %d, Policies: %d, Providers: %v, ProviderChain: %v (%s), Timeout: %ds, CacheType: %s, CacheTTL: %ds, SingleUseTokens: %t, ShadowMode: %t, AccountDefender: %t, RedisURL: %s, RedisMode: %s, FailureMode: %s, CircuitBreaker: %t, Port: %d, GRPCEnabled: %t, GRPCPort: %d, AuthzPathPrefix: %s, TrustedProxies: %d, MockMode: %t}",
+		c.RecaptchaBackend,
 		c.RecaptchaProjectID,
 		c.RecaptchaSiteKey,
 		c.RecaptchaAction,
 		c.RecaptchaV3Threshold,
+		len(c.SiteKeys),
+		len(c.Policies),
+		c.Providers(),
+		c.ProviderChain,
+		c.ProviderChainMode,
 		c.GoogleAPITimeoutSeconds,
+		c.CacheType,
 		c.CacheTTLSeconds,
-		c.RedisURL,
+		c.SingleUseTokens,
+		c.ShadowMode,
+		c.AccountIDHMACKey != "",
+		redactURL(c.RedisURL),
+		c.RedisMode,
 		c.FailureMode,
 		c.CircuitBreakerEnabled,
 		c.Port,
+		c.GRPCEnabled,
+		c.GRPCPort,
+		c.AuthzPathPrefix,
+		len(c.TrustedProxies),
 		c.MockMode,
 	)
 } 
\ No newline at end of file
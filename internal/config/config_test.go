@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/prefeitura-rio/app-ext-authz/internal/recaptcha"
+)
+
+// loadTestConfig loads the configuration from the environment, with the
+// required reCAPTCHA settings set
+func loadTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	t.Setenv("RECAPTCHA_PROJECT_ID", "test-project")
+	t.Setenv("RECAPTCHA_SITE_KEY", "test_site_key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	return cfg
+}
+
+func TestValidate_AuthzPathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix      string
+		expectError bool
+	}{
+		{prefix: "/authz"},
+		{prefix: "/api/authz"},
+		{prefix: "/healthz"},
+		{prefix: "/", expectError: true},
+		{prefix: "authz", expectError: true},
+		{prefix: "/health", expectError: true},
+		{prefix: "/health/x", expectError: true},
+		{prefix: "/metrics", expectError: true},
+		{prefix: "/nginx", expectError: true},
+		{prefix: "/nginx/auth", expectError: true},
+		{prefix: "/traefik/auth/check", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			cfg := loadTestConfig(t)
+			cfg.AuthzPathPrefix = tt.prefix
+
+			err := cfg.Validate()
+			if tt.expectError && err == nil {
+				t.Errorf("Expected prefix %s to be rejected", tt.prefix)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected prefix %s to be accepted, got: %v", tt.prefix, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ProviderDefaults(t *testing.T) {
+	t.Setenv("TURNSTILE_SECRET_KEY", "turnstile-secret")
+	t.Setenv("HCAPTCHA_SECRET_KEY", "hcaptcha-secret")
+
+	cfg := loadTestConfig(t)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected only the secret keys to be required, got: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "turnstile verify URL", value: cfg.TurnstileVerifyURL, expected: recaptcha.DefaultTurnstileURL},
+		{name: "turnstile token header", value: cfg.TurnstileTokenHeader, expected: "X-Turnstile-Token"},
+		{name: "hcaptcha verify URL", value: cfg.HCaptchaVerifyURL, expected: recaptcha.DefaultHCaptchaURL},
+		{name: "hcaptcha token header", value: cfg.HCaptchaTokenHeader, expected: "X-Hcaptcha-Token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// clientIP returns the address of the client behind the proxies. X-Forwarded-For
+// is walked from the right, starting at the connecting peer, for as long as
+// each hop is a trusted proxy; the first untrusted hop is the client. With no
+// trusted proxies the peer itself is the client.
+func (c Config) clientIP(forwardedFor, remoteAddr string) string {
+	ip := parseIP(remoteAddr)
+	if !ip.IsValid() {
+		return ""
+	}
+
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0 && c.trusted(ip); i-- {
+		hop := parseIP(strings.TrimSpace(hops[i]))
+		if !hop.IsValid() {
+			break
+		}
+		ip = hop
+	}
+
+	return ip.String()
+}
+
+// trusted reports whether ip belongs to a trusted proxy
+func (c Config) trusted(ip netip.Addr) bool {
+	for _, prefix := range c.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP parses an address with or without a port
+func parseIP(addr string) netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		return ip.Unmap()
+	}
+	return netip.Addr{}
+}
+
+// header returns the value of an optional header read with get, or "" when
+// the header is not configured
+func (c Config) header(get func(header string) string, name string) string {
+	if name == "" {
+		return ""
+	}
+	return get(name)
+}
+
+// requestedURI rebuilds the URI the client requested. The scheme defaults to
+// https; without a host only the path is known.
+func requestedURI(scheme, host, path string) string {
+	if host == "" {
+		return path
+	}
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + host + path
+}
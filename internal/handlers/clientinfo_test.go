@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestConfig_ClientIP(t *testing.T) {
+	config := Config{
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("fd00::/8"),
+		},
+	}
+
+	tests := []struct {
+		name         string
+		forwardedFor string
+		remoteAddr   string
+		expected     string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:51234",
+			expected:   "203.0.113.7",
+		},
+		{
+			name:         "untrusted peer ignores forwarded for",
+			forwardedFor: "198.51.100.1",
+			remoteAddr:   "203.0.113.7:51234",
+			expected:     "203.0.113.7",
+		},
+		{
+			name:         "trusted peer",
+			forwardedFor: "198.51.100.1",
+			remoteAddr:   "10.1.2.3:51234",
+			expected:     "198.51.100.1",
+		},
+		{
+			name:         "trusted proxy chain",
+			forwardedFor: "198.51.100.1, 10.0.0.5, 10.0.0.6",
+			remoteAddr:   "10.1.2.3:51234",
+			expected:     "198.51.100.1",
+		},
+		{
+			name:         "spoofed leftmost hop",
+			forwardedFor: "1.2.3.4, 198.51.100.1, 10.0.0.5",
+			remoteAddr:   "10.1.2.3:51234",
+			expected:     "198.51.100.1",
+		},
+		{
+			name:         "malformed hop stops the walk",
+			forwardedFor: "198.51.100.1, garbage",
+			remoteAddr:   "10.1.2.3:51234",
+			expected:     "10.1.2.3",
+		},
+		{
+			name:         "ipv6",
+			forwardedFor: "2001:db8::1",
+			remoteAddr:   "[fd00::1]:51234",
+			expected:     "2001:db8::1",
+		},
+		{
+			name:       "address without port",
+			remoteAddr: "203.0.113.7",
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "invalid peer",
+			remoteAddr: "pipe",
+			expected:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.clientIP(tt.forwardedFor, tt.remoteAddr); got != tt.expected {
+				t.Errorf("clientIP() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
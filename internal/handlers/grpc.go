@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/prefeitura-rio/app-ext-authz/internal/service"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// context_extensions keys selecting a named policy and site key
+const (
+	policyExtension  = "policy"
+	siteKeyExtension = "site_key"
+)
+
+// GRPCHandler implements the Envoy ext_authz v3 Authorization service
+type GRPCHandler struct {
+	authv3.UnimplementedAuthorizationServer
+	config  Config
+	service *service.Service
+}
+
+// NewGRPCHandler creates a new gRPC ext_authz handler
+func NewGRPCHandler(svc *service.Service, config Config) *GRPCHandler {
+	return &GRPCHandler{
+		config:  config,
+		service: svc,
+	}
+}
+
+// Register registers the Authorization service on a gRPC server
+func (h *GRPCHandler) Register(s *grpc.Server) {
+	authv3.RegisterAuthorizationServer(s, h)
+}
+
+// Check handles envoy.service.auth.v3.Authorization/Check requests
+func (h *GRPCHandler) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	startTime := time.Now()
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	headers := httpReq.GetHeaders()
+
+	// Extract token from the first token header present. Envoy lowercases
+	// header names in CheckRequest attributes.
+	getHeader := func(header string) string {
+		return headers[strings.ToLower(header)]
+	}
+	token, provider := h.config.findToken(getHeader)
+	if token == "" {
+		return deniedResponse(codes.InvalidArgument, typev3.StatusCode_BadRequest, nil,
+			"X-Recaptcha-Token header is required"), nil
+	}
+
+	// Create authorization request. Envoy forwards every client header, so
+	// the policy and site key come only from the route's context_extensions.
+	extensions := req.GetAttributes().GetContextExtensions()
+	authReq := &service.AuthorizationRequest{
+		Token:           token,
+		Method:          httpReq.GetMethod(),
+		Path:            httpReq.GetPath(),
+		Policy:          extensions[policyExtension],
+		SiteKey:         extensions[siteKeyExtension],
+		StrictSelectors: true,
+		Provider:        provider,
+		Tokens:          h.config.findTokens(getHeader),
+
+		// Client signals for the assessment. The source address is the
+		// downstream peer of Envoy.
+		ClientIP:     h.config.clientIP(headers["x-forwarded-for"], req.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress()),
+		UserAgent:    headers["user-agent"],
+		JA3:          h.config.header(getHeader, h.config.JA3Header),
+		RequestedURI: requestedURI(httpReq.GetScheme(), httpReq.GetHost(), httpReq.GetPath()),
+
+		AccountID: h.config.header(getHeader, h.config.AccountIDHeader),
+	}
+
+	// Call service
+	response, err := h.service.Authorize(ctx, authReq)
+	if errors.Is(err, service.ErrUnknownSelector) {
+		return deniedResponse(codes.PermissionDenied, typev3.StatusCode_Forbidden, nil,
+			"Unknown policy or site key"), nil
+	}
+	if err != nil {
+		return deniedResponse(codes.Internal, typev3.StatusCode_InternalServerError, nil,
+			"Internal server error"), nil
+	}
+
+	// Collect response headers
+	var headerOptions []*corev3.HeaderValueOption
+	h.config.writeRecaptchaHeaders(response, func(key, value string) {
+		headerOptions = append(headerOptions, &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: key, Value: value},
+		})
+	})
+
+	// Add span attributes
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(
+			attribute.String("rpc.method", "Check"),
+			attribute.String("recaptcha.status", response.Status),
+			attribute.String("recaptcha.cache", response.Cache),
+			attribute.Bool("recaptcha.allowed", response.Allowed),
+			attribute.String("recaptcha.risk_level", response.RiskLevel),
+			attribute.Int64("response_time_ms", time.Since(startTime).Milliseconds()),
+		)
+	}
+
+	if !response.Allowed {
+		return deniedResponse(codes.PermissionDenied, typev3.StatusCode(responseStatus(response)), headerOptions, ""), nil
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: headerOptions,
+			},
+		},
+	}, nil
+}
+
+// deniedResponse builds a CheckResponse that denies the request
+func deniedResponse(code codes.Code, httpStatus typev3.StatusCode, headers []*corev3.HeaderValueOption, body string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{
+			Code:    int32(code),
+			Message: strings.ToLower(http.StatusText(int(httpStatus))),
+		},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: httpStatus},
+				Headers: headers,
+				Body:    body,
+			},
+		},
+	}
+}
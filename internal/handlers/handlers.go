@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prefeitura-rio/app-ext-authz/internal/recaptcha"
 	"github.com/prefeitura-rio/app-ext-authz/internal/service"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -18,14 +24,95 @@ type contextKey string
 
 const requestIDKey contextKey = "request_id"
 
+// statusCodeHeader carries the decision's own status on routes that answer
+// with a different one
+const statusCodeHeader = "X-Recaptcha-Status-Code"
+
+// Config holds HTTP handler configuration
+type Config struct {
+	PathPrefix    string // Authorization route prefix, e.g. "/authz"
+	PolicyHeader  string // Header selecting a named policy, set by Envoy in HTTP mode
+	SiteKeyHeader string // Header selecting a site key, set by Envoy in HTTP mode
+
+	// Token headers in lookup order; empty reads reCAPTCHA tokens from
+	// X-Recaptcha-Token
+	TokenHeaders []TokenHeader
+
+	// Bearer key for the annotation endpoint; empty disables the endpoint
+	AnnotationAPIKey string
+
+	// Bearer key for the password leak endpoint; empty disables the endpoint
+	PasswordLeakAPIKey string
+
+	// Upstream header listing risk analysis reasons; empty uses X-Recaptcha-Reasons
+	ReasonsHeader string
+
+	// Proxies whose X-Forwarded-For entries are trusted when resolving the
+	// client IP
+	TrustedProxies []netip.Prefix
+
+	// Header carrying the client's JA3 TLS fingerprint; empty skips it
+	JA3Header string
+
+	// Header carrying the account identifier for Account Defender; empty
+	// disables it
+	AccountIDHeader string
+
+	// Upstream header listing Account Defender labels; empty uses
+	// X-Recaptcha-Account-Labels
+	AccountLabelsHeader string
+}
+
+// TokenHeader maps a request header to the CAPTCHA provider whose tokens it carries
+type TokenHeader struct {
+	Provider string
+	Header   string
+}
+
+// defaultTokenHeaders reads reCAPTCHA tokens from X-Recaptcha-Token
+var defaultTokenHeaders = []TokenHeader{{Provider: "recaptcha", Header: "X-Recaptcha-Token"}}
+
+// tokenHeaders returns the configured token headers or the default
+func (c Config) tokenHeaders() []TokenHeader {
+	if len(c.TokenHeaders) == 0 {
+		return defaultTokenHeaders
+	}
+	return c.TokenHeaders
+}
+
+// findToken returns the first token found by get among the configured token
+// headers, and the provider that issued it
+func (c Config) findToken(get func(header string) string) (token, provider string) {
+	for _, th := range c.tokenHeaders() {
+		if token := get(th.Header); token != "" {
+			return token, th.Provider
+		}
+	}
+	return "", ""
+}
+
+// findTokens returns every token found by get among the configured token
+// headers, keyed by provider
+func (c Config) findTokens(get func(header string) string) map[string]string {
+	tokens := make(map[string]string)
+	for _, th := range c.tokenHeaders() {
+		if token := get(th.Header); token != "" {
+			tokens[th.Provider] = token
+		}
+	}
+	return tokens
+}
+
 // Handler handles HTTP requests
 type Handler struct {
+	config  Config
 	service *service.Service
 }
 
 // NewHandler creates a new HTTP handler
-func NewHandler(svc *service.Service) *Handler {
+func NewHandler(svc *service.Service, config Config) *Handler {
 	return &Handler{
+		config:  config,
 		service: svc,
 	}
 }
@@ -44,22 +131,91 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	// Metrics
 	r.GET("/metrics", h.metricsHandler)
 
-	// Authorization endpoint
-	r.POST("/authz", h.authorizationHandler)
+	// Authorization endpoint. Envoy's http_service appends the original
+	// path to path_prefix and keeps the original method, so accept any
+	// method on the prefix and everything below it.
+	authz := r.Group(h.config.PathPrefix)
+	authz.Any("", h.authorizationHandler)
+	authz.Any("/*path", h.authorizationHandler)
+
+	// nginx auth_request and Traefik ForwardAuth compatibility endpoints
+	r.GET("/nginx/auth", h.nginxAuthHandler)
+	r.Any("/traefik/auth", h.traefikAuthHandler)
+
+	// Assessment annotation endpoint for backends reporting outcomes
+	if h.config.AnnotationAPIKey != "" {
+		r.POST("/annotations", h.annotationHandler)
+	}
+
+	// Password leak check endpoint for login backends
+	if h.config.PasswordLeakAPIKey != "" {
+		r.POST("/password-leak", h.passwordLeakHandler)
+	}
 
 	// Root endpoint
 	r.GET("/", h.rootHandler)
 }
 
-// authorizationHandler handles authorization requests
+// authorizationHandler handles Envoy http_service authorization requests.
+// Envoy only forwards the client headers listed in allowed_headers, so the
+// policy and site key headers are trusted as set by headers_to_add.
 func (h *Handler) authorizationHandler(c *gin.Context) {
+	h.serveAuthorization(c, c.Request.Method, h.originalPath(c), h.headerSelectors(c), http.StatusBadRequest, nil)
+}
+
+// nginxAuthHandler handles nginx auth_request subrequests. nginx only
+// understands 2xx, 401 and 403, and passes the original request line in
+// headers set with proxy_set_header.
+func (h *Handler) nginxAuthHandler(c *gin.Context) {
+	h.serveAuthorization(c, c.GetHeader("X-Original-Method"), c.GetHeader("X-Original-URI"), querySelectors(c), http.StatusUnauthorized, nginxStatus)
+}
+
+// traefikAuthHandler handles Traefik ForwardAuth requests, which carry the
+// original request in X-Forwarded-* headers
+func (h *Handler) traefikAuthHandler(c *gin.Context) {
+	h.serveAuthorization(c, c.GetHeader("X-Forwarded-Method"), c.GetHeader("X-Forwarded-Uri"), querySelectors(c), http.StatusUnauthorized, nil)
+}
+
+// selectors name the policy and site key of an authorization request
+type selectors struct {
+	Policy  string
+	SiteKey string
+	Strict  bool // Reject an unknown policy or site key instead of using the default
+}
+
+// headerSelectors reads the selectors from POLICY_HEADER and SITE_KEY_HEADER
+func (h *Handler) headerSelectors(c *gin.Context) selectors {
+	return selectors{
+		Policy:  c.GetHeader(h.config.PolicyHeader),
+		SiteKey: c.GetHeader(h.config.SiteKeyHeader),
+	}
+}
+
+// querySelectors reads the selectors from the policy and site_key query
+// parameters of the auth URL. nginx and Traefik copy client headers into the
+// auth request, but the auth URL is proxy configuration, so the selectors are
+// never taken from headers there, and unknown ones are rejected like gRPC
+// context extensions.
+func querySelectors(c *gin.Context) selectors {
+	return selectors{
+		Policy:  c.Query("policy"),
+		SiteKey: c.Query("site_key"),
+		Strict:  true,
+	}
+}
+
+// serveAuthorization authorizes the original request described by method and
+// path and writes the decision. missingTokenStatus is returned when the
+// token header is absent. mapStatus, if set, maps the decision's status to
+// the one written, and the decision's own status goes in X-Recaptcha-Status-Code.
+func (h *Handler) serveAuthorization(c *gin.Context, method, path string, sel selectors, missingTokenStatus int, mapStatus func(status int, response *service.AuthorizationResponse) int) {
 	ctx := c.Request.Context()
 	startTime := time.Now()
 
-	// Extract token from header
-	token := c.GetHeader("X-Recaptcha-Token")
+	// Extract token from the first token header present
+	token, provider := h.config.findToken(c.GetHeader)
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(missingTokenStatus, gin.H{
 			"error": "X-Recaptcha-Token header is required",
 		})
 		return
@@ -67,34 +223,241 @@ func (h *Handler) authorizationHandler(c *gin.Context) {
 
 	// Create authorization request
 	req := &service.AuthorizationRequest{
-		Token: token,
+		Token:           token,
+		Method:          method,
+		Path:            path,
+		Policy:          sel.Policy,
+		SiteKey:         sel.SiteKey,
+		StrictSelectors: sel.Strict,
+		Provider:        provider,
+		Tokens:          h.config.findTokens(c.GetHeader),
+
+		// Client signals for the assessment
+		ClientIP:     h.config.clientIP(c.GetHeader("X-Forwarded-For"), c.Request.RemoteAddr),
+		UserAgent:    c.GetHeader("User-Agent"),
+		JA3:          h.config.header(c.GetHeader, h.config.JA3Header),
+		RequestedURI: requestedURI(c.GetHeader("X-Forwarded-Proto"), h.forwardedHost(c), path),
+
+		AccountID: h.config.header(c.GetHeader, h.config.AccountIDHeader),
 	}
 
 	// Call service
 	response, err := h.service.Authorize(ctx, req)
+	if errors.Is(err, service.ErrUnknownSelector) {
+		c.JSON(writtenStatus(c, http.StatusForbidden, nil, mapStatus), gin.H{
+			"error": "Unknown policy or site key",
+		})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(writtenStatus(c, http.StatusInternalServerError, nil, mapStatus), gin.H{
 			"error": "Internal server error",
 		})
 		return
 	}
 
 	// Set response headers
-	c.Header("X-Recaptcha-Status", response.Status)
+	h.config.writeRecaptchaHeaders(response, c.Header)
+
+	// Return response
+	c.Status(writtenStatus(c, responseStatus(response), response, mapStatus))
+
+	// Log request
+	h.logRequest(c, startTime, token, response, err)
+}
+
+// annotationHandler labels a past assessment as legitimate or fraudulent.
+// Callers authenticate with "Authorization: Bearer <ANNOTATION_API_KEY>".
+func (h *Handler) annotationHandler(c *gin.Context) {
+	if !h.authorizeBearer(c, h.config.AnnotationAPIKey) {
+		return
+	}
+
+	var req service.AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	err := h.service.Annotate(c.Request.Context(), &req)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{
+			"status": "annotated",
+		})
+	case errors.Is(err, recaptcha.ErrInvalidAnnotation):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrAnnotationUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrCircuitOpen):
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "reCAPTCHA is unavailable",
+		})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to annotate assessment",
+		})
+	}
+}
+
+// passwordLeakRequest holds the credentials to check. Its fields are never
+// logged.
+type passwordLeakRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// passwordLeakHandler reports whether credentials are known to have leaked.
+// Callers authenticate with "Authorization: Bearer <PASSWORD_LEAK_API_KEY>".
+func (h *Handler) passwordLeakHandler(c *gin.Context) {
+	if !h.authorizeBearer(c, h.config.PasswordLeakAPIKey) {
+		return
+	}
+
+	var req passwordLeakRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	leaked, err := h.service.CheckPasswordLeak(c.Request.Context(), req.Username, req.Password)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{
+			"leaked": leaked,
+		})
+	case errors.Is(err, recaptcha.ErrInvalidCredentials):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrPasswordLeakUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrCircuitOpen):
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "reCAPTCHA is unavailable",
+		})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to check password leak",
+		})
+	}
+}
+
+// authorizeBearer checks the request's bearer key against key in constant
+// time, and writes 401 if it does not match
+func (h *Handler) authorizeBearer(c *gin.Context, key string) bool {
+	expected := "Bearer " + key
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized",
+		})
+		return false
+	}
+	return true
+}
+
+// originalPath returns the original request path (with query string) that
+// Envoy appended after the configured prefix
+func (h *Handler) originalPath(c *gin.Context) string {
+	path := c.Param("path")
+	if path == "" {
+		path = "/"
+	}
+	if c.Request.URL.RawQuery != "" {
+		path += "?" + c.Request.URL.RawQuery
+	}
+	return path
+}
+
+// forwardedHost returns the host of the original request. Traefik passes it
+// in X-Forwarded-Host; Envoy and nginx keep the original Host header.
+func (h *Handler) forwardedHost(c *gin.Context) string {
+	if host := c.GetHeader("X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return c.Request.Host
+}
+
+// writeRecaptchaHeaders writes the X-Recaptcha-* headers for a response
+// using the given setter, so HTTP and gRPC responses carry the same headers
+func (c Config) writeRecaptchaHeaders(response *service.AuthorizationResponse, set func(key, value string)) {
+	set("X-Recaptcha-Status", response.Status)
 	if response.Score != "" {
-		c.Header("X-Recaptcha-Score", response.Score)
+		set("X-Recaptcha-Score", response.Score)
+	}
+	set("X-Recaptcha-Cache", response.Cache)
+	if response.Provider != "" {
+		set("X-Recaptcha-Provider", response.Provider)
 	}
-	c.Header("X-Recaptcha-Cache", response.Cache)
+	if response.Assessment != "" {
+		set("X-Recaptcha-Assessment", response.Assessment)
+	}
+	if len(response.Reasons) > 0 {
+		reasonsHeader := c.ReasonsHeader
+		if reasonsHeader == "" {
+			reasonsHeader = "X-Recaptcha-Reasons"
+		}
+		set(reasonsHeader, strings.Join(response.Reasons, ","))
+	}
+	if response.RiskLevel != "" {
+		set("X-Risk-Level", response.RiskLevel)
+	}
+	if response.ShadowDecision != "" {
+		set("X-Recaptcha-Shadow-Decision", response.ShadowDecision)
+	}
+	if len(response.AccountLabels) > 0 {
+		labelsHeader := c.AccountLabelsHeader
+		if labelsHeader == "" {
+			labelsHeader = "X-Recaptcha-Account-Labels"
+		}
+		set(labelsHeader, strings.Join(response.AccountLabels, ","))
+	}
+}
 
-	// Return response
+// responseStatus returns the HTTP status for a response: its score band
+// status, or else 200 when allowed and 403 when denied
+func responseStatus(response *service.AuthorizationResponse) int {
+	if response.StatusCode != 0 {
+		return response.StatusCode
+	}
 	if response.Allowed {
-		c.Status(http.StatusOK)
-	} else {
-		c.Status(http.StatusForbidden)
+		return http.StatusOK
 	}
+	return http.StatusForbidden
+}
 
-	// Log request
-	h.logRequest(c, startTime, response, err)
+// writtenStatus returns the status to write for a decision's status, mapped
+// by mapStatus if set, in which case the original goes in statusCodeHeader
+func writtenStatus(c *gin.Context, status int, response *service.AuthorizationResponse, mapStatus func(int, *service.AuthorizationResponse) int) int {
+	if mapStatus == nil {
+		return status
+	}
+	c.Header(statusCodeHeader, strconv.Itoa(status))
+	return mapStatus(status, response)
+}
+
+// nginxStatus maps a status to one nginx auth_request understands: 2xx
+// passes through, challenges become 401 and anything else, errors
+// included, 403
+func nginxStatus(status int, response *service.AuthorizationResponse) int {
+	switch {
+	case status >= 200 && status < 300:
+		return status
+	case response != nil && response.Status == "challenge":
+		return http.StatusUnauthorized
+	default:
+		return http.StatusForbidden
+	}
 }
 
 // healthHandler handles health check requests
@@ -111,15 +474,25 @@ func (h *Handler) metricsHandler(c *gin.Context) {
 
 // rootHandler handles root requests
 func (h *Handler) rootHandler(c *gin.Context) {
+	endpoints := gin.H{
+		"authorization": h.config.PathPrefix,
+		"nginx_auth":    "/nginx/auth",
+		"traefik_auth":  "/traefik/auth",
+		"health":        "/health",
+		"metrics":       "/metrics",
+	}
+	if h.config.AnnotationAPIKey != "" {
+		endpoints["annotations"] = "/annotations"
+	}
+	if h.config.PasswordLeakAPIKey != "" {
+		endpoints["password_leak"] = "/password-leak"
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"service": "recaptcha-authz",
-		"version": "1.0.0",
-		"status":  "running",
-		"endpoints": gin.H{
-			"authorization": "/authz",
-			"health":        "/health",
-			"metrics":       "/metrics",
-		},
+		"service":   "recaptcha-authz",
+		"version":   "1.0.0",
+		"status":    "running",
+		"endpoints": endpoints,
 	})
 }
 
@@ -180,11 +553,11 @@ func (h *Handler) loggingMiddleware() gin.HandlerFunc {
 	})
 }
 
-// logRequest logs the request with tracing
-func (h *Handler) logRequest(c *gin.Context, startTime time.Time, response *service.AuthorizationResponse, err error) {
+// logRequest logs the request with tracing. token is the one found in the
+// token headers, of whichever provider.
+func (h *Handler) logRequest(c *gin.Context, startTime time.Time, token string, response *service.AuthorizationResponse, err error) {
 	ctx := c.Request.Context()
 	requestID := c.GetString("request_id")
-	token := c.GetHeader("X-Recaptcha-Token")
 
 	// Add span attributes
 	if span := trace.SpanFromContext(ctx); span.IsRecording() {
@@ -195,6 +568,7 @@ func (h *Handler) logRequest(c *gin.Context, startTime time.Time, response *serv
 			attribute.String("recaptcha.status", response.Status),
 			attribute.String("recaptcha.cache", response.Cache),
 			attribute.Bool("recaptcha.allowed", response.Allowed),
+			attribute.String("recaptcha.risk_level", response.RiskLevel),
 		)
 	}
 
@@ -229,7 +603,3 @@ func (h *Handler) logRequest(c *gin.Context, startTime time.Time, response *serv
 func generateRequestID() string {
 	return fmt.Sprintf("req_%d", time.Now().UnixNano())
 }
-
-
-
- 
\ No newline at end of file
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prefeitura-rio/app-ext-authz/internal/service"
+)
+
+func TestNginxStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response *service.AuthorizationResponse
+		expected int
+	}{
+		{name: "allowed", status: 200, response: &service.AuthorizationResponse{Allowed: true}, expected: 200},
+		{name: "allowed with band status", status: 204, response: &service.AuthorizationResponse{Allowed: true}, expected: 204},
+		{name: "denied", status: 403, response: &service.AuthorizationResponse{Status: "invalid"}, expected: http.StatusForbidden},
+		{name: "denied with band status", status: 429, response: &service.AuthorizationResponse{Status: "invalid"}, expected: http.StatusForbidden},
+		{name: "challenge", status: 428, response: &service.AuthorizationResponse{Status: "challenge"}, expected: http.StatusUnauthorized},
+		{name: "error", status: 500, response: nil, expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nginxStatus(tt.status, tt.response); got != tt.expected {
+				t.Errorf("nginxStatus(%d) = %d, want %d", tt.status, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package observability
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ScoreBuckets are the bucket boundaries of the score histogram, at 0.1
+// steps
+var ScoreBuckets = []float64{0.0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0}
+
+const scoreBucketCount = 11
+
+// scoreWindowSlots is the number of slots a score window is split into; the
+// window slides one slot at a time
+const scoreWindowSlots = 60
+
+// ScoreWindow keeps the distribution of recent scores in process, so it is
+// available without an OTLP collector. Scores are counted in slots and
+// expire a slot at a time.
+type ScoreWindow struct {
+	mu     sync.Mutex
+	window time.Duration
+	slot   time.Duration
+	slots  []scoreSlot
+	now    func() time.Time
+}
+
+type scoreSlot struct {
+	start  time.Time
+	counts map[scoreKey]*[scoreBucketCount]int64
+}
+
+type scoreKey struct {
+	action  string
+	siteKey string
+}
+
+// ScoreDistribution is a snapshot of a score window. Buckets are keyed by
+// their lower bound, "0.0" to "1.0".
+type ScoreDistribution struct {
+	WindowSeconds int64                       `json:"window_seconds"`
+	Total         int64                       `json:"total"`
+	Buckets       map[string]int64            `json:"buckets"`
+	ByAction      map[string]map[string]int64 `json:"by_action"`
+	BySiteKey     map[string]map[string]int64 `json:"by_site_key"`
+}
+
+// NewScoreWindow creates a score window of the given length
+func NewScoreWindow(window time.Duration) *ScoreWindow {
+	slot := window / scoreWindowSlots
+	if slot <= 0 {
+		slot = time.Nanosecond
+	}
+	return &ScoreWindow{
+		window: window,
+		slot:   slot,
+		slots:  make([]scoreSlot, scoreWindowSlots),
+		now:    time.Now,
+	}
+}
+
+// Record counts a score for an action and site key
+func (w *ScoreWindow) Record(score float64, action, siteKey string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	start := now.Truncate(w.slot)
+	slot := &w.slots[(start.UnixNano()/int64(w.slot))%scoreWindowSlots]
+	if !slot.start.Equal(start) {
+		slot.start = start
+		slot.counts = make(map[scoreKey]*[scoreBucketCount]int64)
+	}
+
+	key := scoreKey{action: action, siteKey: siteKey}
+	counts, ok := slot.counts[key]
+	if !ok {
+		counts = new([scoreBucketCount]int64)
+		slot.counts[key] = counts
+	}
+	counts[scoreBucket(score)]++
+}
+
+// Distribution returns the distribution of the scores recorded within the
+// window, overall and by action and site key
+func (w *ScoreWindow) Distribution() ScoreDistribution {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	distribution := ScoreDistribution{
+		WindowSeconds: int64(w.window.Seconds()),
+		Buckets:       newScoreBuckets(),
+		ByAction:      make(map[string]map[string]int64),
+		BySiteKey:     make(map[string]map[string]int64),
+	}
+
+	oldest := w.now().Truncate(w.slot).Add(-w.slot * (scoreWindowSlots - 1))
+	for _, slot := range w.slots {
+		if slot.start.Before(oldest) {
+			continue
+		}
+		for key, counts := range slot.counts {
+			if distribution.ByAction[key.action] == nil {
+				distribution.ByAction[key.action] = newScoreBuckets()
+			}
+			if distribution.BySiteKey[key.siteKey] == nil {
+				distribution.BySiteKey[key.siteKey] = newScoreBuckets()
+			}
+			for i, count := range counts {
+				label := scoreLabel(i)
+				distribution.Total += count
+				distribution.Buckets[label] += count
+				distribution.ByAction[key.action][label] += count
+				distribution.BySiteKey[key.siteKey][label] += count
+			}
+		}
+	}
+
+	return distribution
+}
+
+// scoreBucket returns the index of the 0.1-wide bucket a score falls in.
+// Scores are rounded to two decimals first, so 0.3 lands in 0.3 and not 0.2.
+func scoreBucket(score float64) int {
+	bucket := int(math.Floor(math.Round(score*100) / 10))
+	return max(0, min(bucket, scoreBucketCount-1))
+}
+
+func scoreLabel(bucket int) string {
+	return fmt.Sprintf("%.1f", ScoreBuckets[bucket])
+}
+
+func newScoreBuckets() map[string]int64 {
+	buckets := make(map[string]int64, scoreBucketCount)
+	for i := range scoreBucketCount {
+		buckets[scoreLabel(i)] = 0
+	}
+	return buckets
+}
@@ -0,0 +1,76 @@
+package observability
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScoreBucket(t *testing.T) {
+	tests := []struct {
+		score    float64
+		expected int
+	}{
+		{score: 0.0, expected: 0},
+		{score: 0.05, expected: 0},
+		{score: 0.3, expected: 3},
+		{score: 0.1 + 0.2, expected: 3},
+		{score: 0.99, expected: 9},
+		{score: 1.0, expected: 10},
+		{score: 1.5, expected: 10},
+	}
+
+	for _, tt := range tests {
+		if got := scoreBucket(tt.score); got != tt.expected {
+			t.Errorf("scoreBucket(%v) = %d, want %d", tt.score, got, tt.expected)
+		}
+	}
+}
+
+func TestScoreWindow_Distribution(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	window := NewScoreWindow(time.Minute)
+	window.now = func() time.Time { return now }
+
+	window.Record(0.9, "login", "default")
+	window.Record(0.9, "signup", "default")
+	window.Record(0.1, "login", "mobile")
+
+	distribution := window.Distribution()
+	if distribution.WindowSeconds != 60 {
+		t.Errorf("Expected 60s window, got %d", distribution.WindowSeconds)
+	}
+	if distribution.Total != 3 {
+		t.Errorf("Expected 3 scores, got %d", distribution.Total)
+	}
+	if distribution.Buckets["0.9"] != 2 || distribution.Buckets["0.1"] != 1 || distribution.Buckets["0.5"] != 0 {
+		t.Errorf("Unexpected buckets: %v", distribution.Buckets)
+	}
+	if distribution.ByAction["login"]["0.9"] != 1 || distribution.ByAction["login"]["0.1"] != 1 {
+		t.Errorf("Unexpected login buckets: %v", distribution.ByAction["login"])
+	}
+	if distribution.BySiteKey["default"]["0.9"] != 2 || distribution.BySiteKey["mobile"]["0.1"] != 1 {
+		t.Errorf("Unexpected site key buckets: %v", distribution.BySiteKey)
+	}
+
+	// Half a window later the old scores still count
+	now = now.Add(30 * time.Second)
+	window.Record(0.5, "login", "default")
+	if total := window.Distribution().Total; total != 4 {
+		t.Errorf("Expected 4 scores within the window, got %d", total)
+	}
+
+	// A window after the first scores only the later one remains
+	now = now.Add(30 * time.Second)
+	distribution = window.Distribution()
+	if distribution.Total != 1 || distribution.Buckets["0.5"] != 1 {
+		t.Errorf("Expected only the later score, got %v", distribution.Buckets)
+	}
+
+	// Long after, slots reused by new scores drop the expired ones
+	now = now.Add(time.Hour)
+	window.Record(0.7, "login", "default")
+	distribution = window.Distribution()
+	if distribution.Total != 1 || distribution.Buckets["0.7"] != 1 {
+		t.Errorf("Expected only the latest score, got %v", distribution.Buckets)
+	}
+}
@@ -136,6 +136,9 @@ type Metrics struct {
 	CircuitBreakerTrips     metric.Int64Counter
 	ResponseTime            metric.Float64Histogram
 	ErrorsTotal             metric.Int64Counter
+	ShadowDecisions         metric.Int64Counter
+	Scores                  metric.Float64Histogram
+	CoalescedRequests       metric.Int64Counter
 }
 
 // NewMetrics creates new metrics
@@ -222,6 +225,31 @@ func NewMetrics(meter metric.Meter) (*Metrics, error) {
 		return nil, fmt.Errorf("failed to create errors counter: %w", err)
 	}
 
+	shadowDecisions, err := meter.Int64Counter(
+		"recaptcha_shadow_decisions_total",
+		metric.WithDescription("Total number of shadow decisions, labelled would_deny"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shadow decisions counter: %w", err)
+	}
+
+	scores, err := meter.Float64Histogram(
+		"recaptcha_score",
+		metric.WithDescription("Distribution of token scores"),
+		metric.WithExplicitBucketBoundaries(ScoreBuckets...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create score histogram: %w", err)
+	}
+
+	coalescedRequests, err := meter.Int64Counter(
+		"recaptcha_coalesced_requests_total",
+		metric.WithDescription("Total number of requests answered by a validation shared with concurrent requests"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coalesced requests counter: %w", err)
+	}
+
 	return &Metrics{
 		RequestsTotal:       requestsTotal,
 		ValidationSuccess:   validationSuccess,
@@ -233,31 +261,76 @@ func NewMetrics(meter metric.Meter) (*Metrics, error) {
 		CircuitBreakerTrips: circuitBreakerTrips,
 		ResponseTime:        responseTime,
 		ErrorsTotal:         errorsTotal,
+		ShadowDecisions:     shadowDecisions,
+		Scores:              scores,
+		CoalescedRequests:   coalescedRequests,
 	}, nil
 }
 
+// NewCacheDegradedGauge reports 1 while the cache is bypassed because Redis
+// is unavailable, and 0 otherwise
+func NewCacheDegradedGauge(meter metric.Meter, degraded func() bool) error {
+	_, err := meter.Int64ObservableGauge(
+		"recaptcha_cache_degraded",
+		metric.WithDescription("Whether the cache is bypassed because Redis is unavailable (0=no, 1=yes)"),
+		metric.WithInt64Callback(func(_ context.Context, observer metric.Int64Observer) error {
+			var value int64
+			if degraded() {
+				value = 1
+			}
+			observer.Observe(value)
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create cache degraded gauge: %w", err)
+	}
+	return nil
+}
+
 // LogFields provides common log fields
 type LogFields struct {
-	RequestID     string
-	Token         string
-	ValidationResult string
-	CacheHit      bool
-	ResponseTime  time.Duration
-	Error         error
+	RequestID           string
+	Token               string
+	Provider            string
+	SiteKey             string
+	Policy              string
+	ValidationResult    string
+	Reasons             []string
+	AccountLabels       []string
+	ShadowDecision      string
+	CacheHit            bool
+	ResponseTime        time.Duration
+	Error               error
 	CircuitBreakerState string
 }
 
 // LogRequest logs a request with structured fields
 func (t *Telemetry) LogRequest(fields LogFields) {
 	logFields := logrus.Fields{
-		"request_id":     fields.RequestID,
-		"token_length":   len(fields.Token),
-		"validation_result": fields.ValidationResult,
-		"cache_hit":      fields.CacheHit,
-		"response_time_ms": fields.ResponseTime.Milliseconds(),
+		"request_id":            fields.RequestID,
+		"token_length":          len(fields.Token),
+		"provider":              fields.Provider,
+		"site_key":              fields.SiteKey,
+		"policy":                fields.Policy,
+		"validation_result":     fields.ValidationResult,
+		"cache_hit":             fields.CacheHit,
+		"response_time_ms":      fields.ResponseTime.Milliseconds(),
 		"circuit_breaker_state": fields.CircuitBreakerState,
 	}
 
+	if len(fields.Reasons) > 0 {
+		logFields["reasons"] = fields.Reasons
+	}
+
+	if len(fields.AccountLabels) > 0 {
+		logFields["account_labels"] = fields.AccountLabels
+	}
+
+	if fields.ShadowDecision != "" {
+		logFields["shadow_decision"] = fields.ShadowDecision
+	}
+
 	if fields.Error != nil {
 		logFields["error"] = fields.Error.Error()
 		t.Logger.WithFields(logFields).Error("Request failed")
@@ -267,7 +340,7 @@ func (t *Telemetry) LogRequest(fields LogFields) {
 }
 
 // LogValidation logs validation details
-func (t *Telemetry) LogValidation(requestID, token string, success bool, score float64, errorCodes []string, duration time.Duration) {
+func (t *Telemetry) LogValidation(requestID, token string, success bool, score float64, errorCodes, reasons []string, duration time.Duration) {
 	logFields := logrus.Fields{
 		"request_id":     requestID,
 		"token_length":   len(token),
@@ -283,6 +356,10 @@ func (t *Telemetry) LogValidation(requestID, token string, success bool, score f
 		logFields["error_codes"] = errorCodes
 	}
 
+	if len(reasons) > 0 {
+		logFields["reasons"] = reasons
+	}
+
 	if success {
 		t.Logger.WithFields(logFields).Info("Validation successful")
 	} else {
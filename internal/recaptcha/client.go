@@ -2,6 +2,7 @@ package recaptcha
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -12,17 +13,70 @@ import (
 
 // Client handles reCAPTCHA validation
 type Client interface {
-	Validate(ctx context.Context, token string) (*ValidationResult, error)
+	Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error)
+}
+
+// Annotator sends feedback on past assessments, so Google can learn which
+// of them were fraudulent
+type Annotator interface {
+	Annotate(ctx context.Context, req *AnnotationRequest) error
+}
+
+// AnnotationRequest labels a past assessment
+type AnnotationRequest struct {
+	Assessment string   // Assessment resource name, "projects/*/assessments/*"
+	Annotation string   // "LEGITIMATE" or "FRAUDULENT"
+	Reasons    []string // Reason names, e.g. "CHARGEBACK" or "PASSED_TWO_FACTOR"
+}
+
+// ErrInvalidAnnotation is returned for malformed annotation requests
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+// Provider names
+const (
+	ProviderRecaptcha = "recaptcha"
+	ProviderTurnstile = "turnstile"
+	ProviderHCaptcha  = "hcaptcha"
+)
+
+// ValidationRequest holds a token and the expectations it is judged against
+type ValidationRequest struct {
+	Token     string
+	SiteKey   string   // Key the token was minted for; empty uses Config.SiteKey
+	Actions   []string // Accepted actions; empty uses Config.Action
+	Threshold *float64 // Minimum score; nil uses Config.V3Threshold
+
+	// Tokens by provider, for composite clients; nil uses Token for every provider
+	Tokens map[string]string
+
+	// Client signals sent along with the token; empty values are omitted
+	UserIPAddress string
+	UserAgent     string
+	JA3           string
+	RequestedURI  string
+
+	// HMAC of the account identifier, for Account Defender; nil omits it
+	HashedAccountID []byte
 }
 
 // ValidationResult represents the result of a reCAPTCHA validation
 type ValidationResult struct {
 	Success     bool    `json:"success"`
 	Score       float64 `json:"score,omitempty"`       // Only for v3
+	Scored      bool    `json:"scored,omitempty"`      // Set when a score was returned; 0.0 is a real score
 	Action      string  `json:"action,omitempty"`      // Only for v3
 	ChallengeTS string  `json:"challenge_ts,omitempty"`
 	Hostname    string  `json:"hostname,omitempty"`
 	ErrorCodes  []string `json:"error-codes,omitempty"`
+	Provider    string   `json:"provider,omitempty"` // Set by composite clients
+	Assessment  string   `json:"assessment,omitempty"` // Assessment resource name (Enterprise)
+
+	// Risk analysis reasons, e.g. AUTOMATION (Enterprise)
+	Reasons                []string `json:"reasons,omitempty"`
+	ExtendedVerdictReasons []string `json:"extended_verdict_reasons,omitempty"`
+
+	// Account Defender labels, e.g. SUSPICIOUS_LOGIN_ACTIVITY (Enterprise)
+	AccountLabels []string `json:"account_labels,omitempty"`
 }
 
 
@@ -35,6 +89,17 @@ type Config struct {
 	V3Threshold  float64
 	Timeout      time.Duration
 	MockMode     bool
+
+	// Secret key and verify endpoint for the siteverify-style backends
+	// (legacy reCAPTCHA, Turnstile and hCaptcha); empty uses the default URL
+	SecretKey     string
+	SiteverifyURL string
+
+	// Allow-lists checked against the token properties; empty allows any.
+	// Entries like "*.example.com" match any subdomain.
+	AllowedHostnames       []string
+	AllowedAndroidPackages []string
+	AllowedIOSBundleIDs    []string
 }
 
 // client implements the Client interface
@@ -65,9 +130,10 @@ func NewClient(config *Config) Client {
 }
 
 // Validate validates a reCAPTCHA token using Google Cloud reCAPTCHA Enterprise
-func (c *client) Validate(ctx context.Context, token string) (*ValidationResult, error) {
+func (c *client) Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	token := req.Token
 	if c.config.MockMode {
-		return c.mockValidation(token)
+		return mockValidation(c.config, token)
 	}
 
 	if token == "" {
@@ -78,9 +144,20 @@ func (c *client) Validate(ctx context.Context, token string) (*ValidationResult,
 	}
 
 	// Create assessment request
+	siteKey := req.SiteKey
+	if siteKey == "" {
+		siteKey = c.config.SiteKey
+	}
+
 	event := &recaptchapb.Event{
-		Token:   token,
-		SiteKey: c.config.SiteKey,
+		Token:         token,
+		SiteKey:       siteKey,
+		UserIpAddress: req.UserIPAddress,
+		UserAgent:     req.UserAgent,
+		Ja3:           req.JA3,
+		RequestedUri:  req.RequestedURI,
+
+		HashedAccountId: req.HashedAccountID,
 	}
 
 	assessment := &recaptchapb.Assessment{
@@ -93,6 +170,9 @@ func (c *client) Validate(ctx context.Context, token string) (*ValidationResult,
 	}
 
 	// Create assessment
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
 	response, err := c.client.CreateAssessment(ctx, request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create assessment: %w", err)
@@ -121,28 +201,51 @@ func (c *client) Validate(ctx context.Context, token string) (*ValidationResult,
 		return &ValidationResult{
 			Success:    false,
 			ErrorCodes: errorCodes,
+			Assessment: response.Name,
 		}, nil
 	}
 
-	// Check if action matches expected action
-	if response.TokenProperties.Action != c.config.Action {
+	// Check where the token was minted
+	if code := c.checkOrigin(response.TokenProperties); code != "" {
+		return &ValidationResult{
+			Success:    false,
+			Hostname:   response.TokenProperties.Hostname,
+			ErrorCodes: []string{code},
+			Assessment: response.Name,
+		}, nil
+	}
+
+	// Check if action matches one of the expected actions
+	if !c.config.actionAllowed(req, response.TokenProperties.Action) {
 		return &ValidationResult{
 			Success:    false,
 			ErrorCodes: []string{"action-mismatch"},
+			Assessment: response.Name,
 		}, nil
 	}
 
 	// Get risk analysis
 	score := float64(response.RiskAnalysis.Score)
-	success := score >= c.config.V3Threshold
+	success := score >= c.config.threshold(req)
+
+	reasons := make([]string, 0, len(response.RiskAnalysis.Reasons))
+	for _, reason := range response.RiskAnalysis.Reasons {
+		reasons = append(reasons, reason.String())
+	}
 
 	result := &ValidationResult{
 		Success:     success,
 		Score:       score,
+		Scored:      true,
 		Action:      response.TokenProperties.Action,
 		ChallengeTS: response.TokenProperties.CreateTime.AsTime().Format(time.RFC3339),
 		Hostname:    response.TokenProperties.Hostname,
 		ErrorCodes:  []string{},
+		Assessment:  response.Name,
+
+		Reasons:                reasons,
+		ExtendedVerdictReasons: response.RiskAnalysis.GetExtendedVerdictReasons(),
+		AccountLabels:          accountLabels(response.AccountDefenderAssessment),
 	}
 
 	// If score is below threshold, add error code
@@ -153,15 +256,133 @@ func (c *client) Validate(ctx context.Context, token string) (*ValidationResult,
 	return result, nil
 }
 
+// accountLabels returns the Account Defender label names of an assessment
+func accountLabels(assessment *recaptchapb.AccountDefenderAssessment) []string {
+	labels := make([]string, 0, len(assessment.GetLabels()))
+	for _, label := range assessment.GetLabels() {
+		labels = append(labels, label.String())
+	}
+	return labels
+}
+
+// Annotate labels a past assessment as legitimate or fraudulent
+func (c *client) Annotate(ctx context.Context, req *AnnotationRequest) error {
+	if err := req.Check(); err != nil {
+		return err
+	}
+
+	request := &recaptchapb.AnnotateAssessmentRequest{
+		Name: req.Assessment,
+		Annotation: recaptchapb.AnnotateAssessmentRequest_Annotation(
+			recaptchapb.AnnotateAssessmentRequest_Annotation_value[req.Annotation]),
+	}
+
+	for _, reason := range req.Reasons {
+		request.Reasons = append(request.Reasons, recaptchapb.AnnotateAssessmentRequest_Reason(
+			recaptchapb.AnnotateAssessmentRequest_Reason_value[reason]))
+	}
+
+	if c.config.MockMode {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	if _, err := c.client.AnnotateAssessment(ctx, request); err != nil {
+		return fmt.Errorf("failed to annotate assessment: %w", err)
+	}
+
+	return nil
+}
+
+// Check reports whether the annotation request is well formed
+func (r *AnnotationRequest) Check() error {
+	if r.Assessment == "" {
+		return fmt.Errorf("%w: assessment is required", ErrInvalidAnnotation)
+	}
+
+	if r.Annotation != "LEGITIMATE" && r.Annotation != "FRAUDULENT" {
+		return fmt.Errorf("%w: annotation must be LEGITIMATE or FRAUDULENT", ErrInvalidAnnotation)
+	}
+
+	for _, reason := range r.Reasons {
+		if _, ok := recaptchapb.AnnotateAssessmentRequest_Reason_value[reason]; !ok || reason == "REASON_UNSPECIFIED" {
+			return fmt.Errorf("%w: unknown reason %q", ErrInvalidAnnotation, reason)
+		}
+	}
+
+	return nil
+}
+
+// checkOrigin checks the token's hostname, Android package name and iOS
+// bundle ID against the allow-lists and returns an error code on mismatch
+func (c *client) checkOrigin(props *recaptchapb.TokenProperties) string {
+	if props.Hostname != "" && !matchAllowList(props.Hostname, c.config.AllowedHostnames) {
+		return "hostname-mismatch"
+	}
+	if props.AndroidPackageName != "" && !matchAllowList(props.AndroidPackageName, c.config.AllowedAndroidPackages) {
+		return "android-package-mismatch"
+	}
+	if props.IosBundleId != "" && !matchAllowList(props.IosBundleId, c.config.AllowedIOSBundleIDs) {
+		return "ios-bundle-id-mismatch"
+	}
+	return ""
+}
+
+// matchAllowList reports whether value is in the allow-list. An empty list
+// allows everything; "*.example.com" matches subdomains of example.com.
+func matchAllowList(value string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	value = strings.ToLower(value)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if strings.HasPrefix(entry, "*.") {
+			if strings.HasSuffix(value, entry[1:]) {
+				return true
+			}
+			continue
+		}
+		if value == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// actionAllowed reports whether action is one of the request's expected actions
+func (c *Config) actionAllowed(req *ValidationRequest, action string) bool {
+	if len(req.Actions) == 0 {
+		return action == c.Action
+	}
+	for _, expected := range req.Actions {
+		if action == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// threshold returns the score threshold for the request
+func (c *Config) threshold(req *ValidationRequest) float64 {
+	if req.Threshold != nil {
+		return *req.Threshold
+	}
+	return c.V3Threshold
+}
+
 // mockValidation provides mock responses for testing
-func (c *client) mockValidation(token string) (*ValidationResult, error) {
+func mockValidation(config *Config, token string) (*ValidationResult, error) {
 	// Mock different scenarios based on token
 	switch token {
 	case "valid_token":
 		return &ValidationResult{
 			Success:     true,
 			Score:       0.9,
-			Action:      c.config.Action,
+			Scored:      true,
+			Action:      config.Action,
 			ChallengeTS: time.Now().Format(time.RFC3339),
 			Hostname:    "localhost",
 		}, nil
@@ -176,15 +397,28 @@ func (c *client) mockValidation(token string) (*ValidationResult, error) {
 		return &ValidationResult{
 			Success:     false,
 			Score:       0.1,
-			Action:      c.config.Action,
+			Scored:      true,
+			Action:      config.Action,
 			ChallengeTS: time.Now().Format(time.RFC3339),
 			Hostname:    "localhost",
 			ErrorCodes:  []string{"score-below-threshold"},
+			Reasons:     []string{"AUTOMATION"},
+		}, nil
+
+	case "suspicious_login_token":
+		return &ValidationResult{
+			Success:       true,
+			Score:         0.7,
+			Scored:        true,
+			Action:        config.Action,
+			ChallengeTS:   time.Now().Format(time.RFC3339),
+			Hostname:      "localhost",
+			AccountLabels: []string{"SUSPICIOUS_LOGIN_ACTIVITY"},
 		}, nil
 
 	case "timeout_token":
 		// Simulate timeout
-		time.Sleep(c.config.Timeout + time.Second)
+		time.Sleep(config.Timeout + time.Second)
 		return nil, fmt.Errorf("timeout")
 
 	case "error_token":
@@ -205,7 +439,8 @@ func (c *client) mockValidation(token string) (*ValidationResult, error) {
 		return &ValidationResult{
 			Success:     true,
 			Score:       0.8,
-			Action:      c.config.Action,
+			Scored:      true,
+			Action:      config.Action,
 			ChallengeTS: time.Now().Format(time.RFC3339),
 			Hostname:    "localhost",
 		}, nil
@@ -233,7 +468,7 @@ func (r *ValidationResult) GetErrorCodes() string {
 // String returns a string representation of the validation result
 func (r *ValidationResult) String() string {
 	if r.Success {
-		if r.Score > 0 {
+		if r.Scored {
 			return fmt.Sprintf("valid (score: %.2f)", r.Score)
 		}
 		return "valid"
@@ -24,11 +24,11 @@ func TestNewClient(t *testing.T) {
 
 func TestClient_Validate_MockMode(t *testing.T) {
 	tests := []struct {
-		name           string
-		token          string
-		expectedValid  bool
-		expectedScore  float64
-		expectedError  bool
+		name          string
+		token         string
+		expectedValid bool
+		expectedScore float64
+		expectedError bool
 	}{
 		{
 			name:          "valid token",
@@ -83,19 +83,19 @@ func TestClient_Validate_MockMode(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-					config := &Config{
-			ProjectID:   "test-project",
-			SiteKey:     "test_site_key",
-			Action:      "authz",
-			V3Threshold: 0.5,
-			Timeout:     5 * time.Second,
-			MockMode:    true,
-		}
+			config := &Config{
+				ProjectID:   "test-project",
+				SiteKey:     "test_site_key",
+				Action:      "authz",
+				V3Threshold: 0.5,
+				Timeout:     5 * time.Second,
+				MockMode:    true,
+			}
 
 			client := NewClient(config)
 			ctx := context.Background()
 
-			result, err := client.Validate(ctx, tt.token)
+			result, err := client.Validate(ctx, &ValidationRequest{Token: tt.token})
 
 			if tt.expectedError {
 				if err == nil {
@@ -227,9 +227,18 @@ func TestValidationResult_String(t *testing.T) {
 			result: &ValidationResult{
 				Success: true,
 				Score:   0.9,
+				Scored:  true,
 			},
 			expected: "valid (score: 0.90)",
 		},
+		{
+			name: "valid token with zero score",
+			result: &ValidationResult{
+				Success: true,
+				Scored:  true,
+			},
+			expected: "valid (score: 0.00)",
+		},
 		{
 			name: "invalid token",
 			result: &ValidationResult{
@@ -263,7 +272,7 @@ func TestClient_Validate_V3Threshold(t *testing.T) {
 	ctx := context.Background()
 
 	// Test with a token that would normally be valid but has low score
-	result, err := client.Validate(ctx, "low_score_token")
+	result, err := client.Validate(ctx, &ValidationRequest{Token: "low_score_token"})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -299,7 +308,7 @@ func TestClient_Validate_EmptyToken(t *testing.T) {
 	client := NewClient(config)
 	ctx := context.Background()
 
-	result, err := client.Validate(ctx, "")
+	result, err := client.Validate(ctx, &ValidationRequest{Token: ""})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -318,4 +327,70 @@ func TestClient_Validate_EmptyToken(t *testing.T) {
 	if !found {
 		t.Error("Expected missing-input-response error code")
 	}
-} 
\ No newline at end of file
+}
+
+func TestMatchAllowList(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		allowed  []string
+		expected bool
+	}{
+		{
+			name:     "empty list allows any",
+			value:    "evil.example.net",
+			allowed:  nil,
+			expected: true,
+		},
+		{
+			name:     "exact match",
+			value:    "www.rio.rj.gov.br",
+			allowed:  []string{"www.rio.rj.gov.br"},
+			expected: true,
+		},
+		{
+			name:     "case insensitive",
+			value:    "WWW.Rio.rj.gov.br",
+			allowed:  []string{"www.rio.rj.gov.br"},
+			expected: true,
+		},
+		{
+			name:     "wildcard subdomain",
+			value:    "app.carioca.rio",
+			allowed:  []string{"*.carioca.rio"},
+			expected: true,
+		},
+		{
+			name:     "wildcard nested subdomain",
+			value:    "a.b.carioca.rio",
+			allowed:  []string{"*.carioca.rio"},
+			expected: true,
+		},
+		{
+			name:     "wildcard does not match apex",
+			value:    "carioca.rio",
+			allowed:  []string{"*.carioca.rio"},
+			expected: false,
+		},
+		{
+			name:     "wildcard does not match suffix without dot",
+			value:    "evilcarioca.rio",
+			allowed:  []string{"*.carioca.rio"},
+			expected: false,
+		},
+		{
+			name:     "no match",
+			value:    "example.com",
+			allowed:  []string{"www.rio.rj.gov.br", "*.carioca.rio"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchAllowList(tt.value, tt.allowed); got != tt.expected {
+				t.Errorf("matchAllowList(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package recaptcha
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prefeitura-rio/app-ext-authz/internal/circuitbreaker"
+)
+
+// Composite chain modes
+const (
+	ModeFallback = "fallback" // First provider that answers decides
+	ModeAnyOf    = "any_of"   // Allowed if any provider accepts its token
+	ModeAllOf    = "all_of"   // Allowed only if every provider accepts its token
+)
+
+// Member is a provider in a composite chain
+type Member struct {
+	Provider string
+	Client   Client
+	Breaker  *circuitbreaker.Breaker // nil disables the member's breaker
+	Timeout  time.Duration           // 0 leaves the timeout to the client
+}
+
+// compositeClient implements the Client interface by combining providers
+type compositeClient struct {
+	mode    string
+	members []Member
+}
+
+// NewCompositeClient creates a client that runs members in order according
+// to mode. Each member validates the token in ValidationRequest.Tokens for
+// its provider.
+func NewCompositeClient(mode string, members []Member) Client {
+	return &compositeClient{
+		mode:    mode,
+		members: members,
+	}
+}
+
+// Validate validates the request's tokens with the chain's providers and
+// sets ValidationResult.Provider to the provider(s) that decided
+func (c *compositeClient) Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	switch c.mode {
+	case ModeAllOf:
+		return c.validateAllOf(ctx, req)
+	case ModeAnyOf:
+		return c.validateAnyOf(ctx, req)
+	default:
+		return c.validateFallback(ctx, req)
+	}
+}
+
+// validateFallback returns the result of the first provider with a token
+// that answers; errors and open breakers move on to the next provider
+func (c *compositeClient) validateFallback(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	var lastErr error
+	for _, member := range c.members {
+		memberReq, ok := c.memberRequest(req, member.Provider)
+		if !ok {
+			continue
+		}
+
+		result, err := c.call(ctx, member, memberReq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return result, nil
+	}
+
+	return c.noResult(lastErr)
+}
+
+// validateAnyOf returns the first accepting result, or else the first
+// rejecting one
+func (c *compositeClient) validateAnyOf(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	var rejected *ValidationResult
+	var lastErr error
+	for _, member := range c.members {
+		memberReq, ok := c.memberRequest(req, member.Provider)
+		if !ok {
+			continue
+		}
+
+		result, err := c.call(ctx, member, memberReq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if result.IsValidToken() {
+			return result, nil
+		}
+		if rejected == nil {
+			rejected = result
+		}
+	}
+
+	if rejected != nil {
+		return rejected, nil
+	}
+	return c.noResult(lastErr)
+}
+
+// validateAllOf requires every provider to accept its token. The first
+// rejection decides; otherwise all providers decided together.
+func (c *compositeClient) validateAllOf(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	var accepted *ValidationResult
+	providers := make([]string, 0, len(c.members))
+	for _, member := range c.members {
+		memberReq, ok := c.memberRequest(req, member.Provider)
+		if !ok {
+			return &ValidationResult{
+				Success:    false,
+				Provider:   member.Provider,
+				ErrorCodes: []string{"missing-input-response"},
+			}, nil
+		}
+
+		result, err := c.call(ctx, member, memberReq)
+		if err != nil {
+			return nil, err
+		}
+		if !result.IsValidToken() {
+			return result, nil
+		}
+		if accepted == nil {
+			accepted = result
+		}
+		providers = append(providers, member.Provider)
+	}
+
+	if accepted == nil {
+		return c.noResult(nil)
+	}
+
+	// Report the first provider's details on behalf of the chain
+	result := *accepted
+	result.Provider = strings.Join(providers, ",")
+	return &result, nil
+}
+
+// memberRequest returns a copy of req carrying the member's token. ok is
+// false when the request has no token for the provider.
+func (c *compositeClient) memberRequest(req *ValidationRequest, provider string) (*ValidationRequest, bool) {
+	token := req.Token
+	if req.Tokens != nil {
+		token = req.Tokens[provider]
+	}
+	if token == "" {
+		return nil, false
+	}
+
+	memberReq := *req
+	memberReq.Token = token
+	memberReq.Tokens = nil
+	if provider != ProviderRecaptcha {
+		memberReq.SiteKey = ""
+	}
+	return &memberReq, true
+}
+
+// call validates with one member, honouring its breaker and timeout
+func (c *compositeClient) call(ctx context.Context, member Member, req *ValidationRequest) (*ValidationResult, error) {
+	if member.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, member.Timeout)
+		defer cancel()
+	}
+
+	var result *ValidationResult
+	validate := func() error {
+		var err error
+		result, err = member.Client.Validate(ctx, req)
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+
+	var err error
+	if member.Breaker != nil {
+		err = member.Breaker.Execute(ctx, validate)
+	} else {
+		err = validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", member.Provider, err)
+	}
+
+	if result.Provider == "" {
+		result.Provider = member.Provider
+	}
+	return result, nil
+}
+
+// noResult is returned when no provider produced a result: the last error
+// if one failed, otherwise a missing token
+func (c *compositeClient) noResult(lastErr error) (*ValidationResult, error) {
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return &ValidationResult{
+		Success:    false,
+		ErrorCodes: []string{"missing-input-response"},
+	}, nil
+}
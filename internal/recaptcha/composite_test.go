@@ -0,0 +1,194 @@
+package recaptcha
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prefeitura-rio/app-ext-authz/internal/circuitbreaker"
+)
+
+// stubClient returns a fixed result or error
+type stubClient struct {
+	result *ValidationResult
+	err    error
+	calls  int
+}
+
+func (c *stubClient) Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	result := *c.result
+	return &result, nil
+}
+
+func accepting() *stubClient {
+	return &stubClient{result: &ValidationResult{Success: true, Score: 0.9}}
+}
+
+func rejecting() *stubClient {
+	return &stubClient{result: &ValidationResult{Success: false, ErrorCodes: []string{"score-below-threshold"}}}
+}
+
+func failing() *stubClient {
+	return &stubClient{err: fmt.Errorf("unavailable")}
+}
+
+func TestCompositeClient_Validate(t *testing.T) {
+	tokens := map[string]string{
+		ProviderRecaptcha: "recaptcha_token",
+		ProviderTurnstile: "turnstile_token",
+	}
+
+	tests := []struct {
+		name             string
+		mode             string
+		recaptcha        *stubClient
+		turnstile        *stubClient
+		tokens           map[string]string
+		expectedValid    bool
+		expectedProvider string
+		expectedError    bool
+	}{
+		{
+			name:             "fallback uses first provider",
+			mode:             ModeFallback,
+			recaptcha:        rejecting(),
+			turnstile:        accepting(),
+			tokens:           tokens,
+			expectedValid:    false,
+			expectedProvider: ProviderRecaptcha,
+		},
+		{
+			name:             "fallback skips failing provider",
+			mode:             ModeFallback,
+			recaptcha:        failing(),
+			turnstile:        accepting(),
+			tokens:           tokens,
+			expectedValid:    true,
+			expectedProvider: ProviderTurnstile,
+		},
+		{
+			name:             "fallback skips provider without token",
+			mode:             ModeFallback,
+			recaptcha:        accepting(),
+			turnstile:        rejecting(),
+			tokens:           map[string]string{ProviderTurnstile: "turnstile_token"},
+			expectedValid:    false,
+			expectedProvider: ProviderTurnstile,
+		},
+		{
+			name:          "fallback fails when every provider fails",
+			mode:          ModeFallback,
+			recaptcha:     failing(),
+			turnstile:     failing(),
+			tokens:        tokens,
+			expectedError: true,
+		},
+		{
+			name:             "any_of accepts second provider",
+			mode:             ModeAnyOf,
+			recaptcha:        rejecting(),
+			turnstile:        accepting(),
+			tokens:           tokens,
+			expectedValid:    true,
+			expectedProvider: ProviderTurnstile,
+		},
+		{
+			name:             "any_of rejects when no provider accepts",
+			mode:             ModeAnyOf,
+			recaptcha:        rejecting(),
+			turnstile:        failing(),
+			tokens:           tokens,
+			expectedValid:    false,
+			expectedProvider: ProviderRecaptcha,
+		},
+		{
+			name:             "all_of accepts when every provider accepts",
+			mode:             ModeAllOf,
+			recaptcha:        accepting(),
+			turnstile:        accepting(),
+			tokens:           tokens,
+			expectedValid:    true,
+			expectedProvider: "recaptcha,turnstile",
+		},
+		{
+			name:             "all_of rejects on one rejection",
+			mode:             ModeAllOf,
+			recaptcha:        accepting(),
+			turnstile:        rejecting(),
+			tokens:           tokens,
+			expectedValid:    false,
+			expectedProvider: ProviderTurnstile,
+		},
+		{
+			name:             "all_of rejects missing token",
+			mode:             ModeAllOf,
+			recaptcha:        accepting(),
+			turnstile:        accepting(),
+			tokens:           map[string]string{ProviderRecaptcha: "recaptcha_token"},
+			expectedValid:    false,
+			expectedProvider: ProviderTurnstile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewCompositeClient(tt.mode, []Member{
+				{Provider: ProviderRecaptcha, Client: tt.recaptcha},
+				{Provider: ProviderTurnstile, Client: tt.turnstile},
+			})
+
+			result, err := client.Validate(context.Background(), &ValidationRequest{Tokens: tt.tokens})
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if result.IsValidToken() != tt.expectedValid {
+				t.Errorf("Expected valid=%v, got %v", tt.expectedValid, result.IsValidToken())
+			}
+
+			if result.Provider != tt.expectedProvider {
+				t.Errorf("Expected provider=%v, got %v", tt.expectedProvider, result.Provider)
+			}
+		})
+	}
+}
+
+func TestCompositeClient_Validate_OpenBreaker(t *testing.T) {
+	breaker := circuitbreaker.NewBreaker(circuitbreaker.Config{
+		FailureThreshold: 1,
+		RecoveryTime:     time.Minute,
+	})
+	breaker.Execute(context.Background(), func() error { return fmt.Errorf("unavailable") })
+
+	primary := accepting()
+	client := NewCompositeClient(ModeFallback, []Member{
+		{Provider: ProviderRecaptcha, Client: primary, Breaker: breaker},
+		{Provider: ProviderTurnstile, Client: accepting()},
+	})
+
+	result, err := client.Validate(context.Background(), &ValidationRequest{Token: "token"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if primary.calls != 0 {
+		t.Errorf("Expected open breaker to skip reCAPTCHA, got %d calls", primary.calls)
+	}
+
+	if result.Provider != ProviderTurnstile {
+		t.Errorf("Expected provider=%v, got %v", ProviderTurnstile, result.Provider)
+	}
+}
@@ -0,0 +1,82 @@
+package recaptcha
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// DefaultHCaptchaURL is hCaptcha's verification endpoint
+const DefaultHCaptchaURL = "https://api.hcaptcha.com/siteverify"
+
+// hcaptchaResponse is the JSON body returned by hCaptcha's siteverify
+type hcaptchaResponse struct {
+	Success     bool     `json:"success"`
+	ChallengeTS string   `json:"challenge_ts"`
+	Hostname    string   `json:"hostname"`
+	Score       *float64 `json:"score"` // Enterprise only; 0 is human, 1 is bot
+	ErrorCodes  []string `json:"error-codes"`
+}
+
+// hcaptchaClient implements the Client interface for hCaptcha
+type hcaptchaClient struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+// NewHCaptchaClient creates a new hCaptcha client
+func NewHCaptchaClient(config *Config) Client {
+	return &hcaptchaClient{
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Validate validates an hCaptcha token. hCaptcha Enterprise reports a risk
+// score, which is inverted so that, as with reCAPTCHA, higher is more human.
+func (c *hcaptchaClient) Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	token := req.Token
+	if c.config.MockMode {
+		return mockValidation(c.config, token)
+	}
+
+	if token == "" {
+		return &ValidationResult{
+			Success:    false,
+			ErrorCodes: []string{"missing-input-response"},
+		}, nil
+	}
+
+	verifyURL := c.config.SiteverifyURL
+	if verifyURL == "" {
+		verifyURL = DefaultHCaptchaURL
+	}
+
+	form := url.Values{
+		"secret":   {c.config.SecretKey},
+		"response": {token},
+	}
+	if c.config.SiteKey != "" {
+		form.Set("sitekey", c.config.SiteKey)
+	}
+	if req.UserIPAddress != "" {
+		form.Set("remoteip", req.UserIPAddress)
+	}
+
+	var response hcaptchaResponse
+	if err := postVerify(ctx, c.httpClient, verifyURL, form, &response); err != nil {
+		return nil, err
+	}
+
+	normalized := &siteverifyResponse{
+		Success:     response.Success,
+		ChallengeTS: response.ChallengeTS,
+		Hostname:    response.Hostname,
+		ErrorCodes:  response.ErrorCodes,
+	}
+	if response.Score != nil {
+		normalized.Score = 1 - *response.Score
+	}
+
+	return c.config.judge(req, normalized, response.Score != nil), nil
+}
@@ -0,0 +1,258 @@
+package recaptcha
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	recaptchapb "cloud.google.com/go/recaptchaenterprise/v2/apiv1/recaptchaenterprisepb"
+	"golang.org/x/crypto/scrypt"
+)
+
+// LeakChecker checks credentials against reCAPTCHA Enterprise's database of
+// leaked credentials
+type LeakChecker interface {
+	CheckPasswordLeak(ctx context.Context, username, password string) (bool, error)
+}
+
+// ErrInvalidCredentials is returned when the username or password is empty
+var ErrInvalidCredentials = errors.New("username and password are required")
+
+// Password leak check parameters. They match Chrome's Password Checkup
+// protocol, which reCAPTCHA Enterprise implements on the server side.
+const (
+	usernameHashPrefixBits = 26
+	scryptCost             = 1 << 12
+	scryptBlockSize        = 8
+	scryptParallelism      = 1
+	scryptKeyLength        = 32
+)
+
+var (
+	usernameSalt = []byte{
+		0xC4, 0x94, 0xA3, 0x95, 0xF8, 0xC0, 0xE2, 0x3E, 0xA9, 0x23, 0x04, 0x78, 0x70, 0x2C, 0x72, 0x18,
+		0x56, 0x54, 0x99, 0xB3, 0xE9, 0x21, 0x18, 0x6C, 0x21, 0x1A, 0x01, 0x22, 0x3C, 0x45, 0x4A, 0xFA,
+	}
+	credentialsSalt = []byte{
+		0x30, 0x76, 0x2A, 0xD2, 0x3F, 0x7B, 0xA1, 0x9B, 0xF8, 0xE3, 0x42, 0xFC, 0xA1, 0xA7, 0x8D, 0x06,
+		0xE6, 0x6B, 0xE4, 0xDB, 0xB8, 0x4F, 0x81, 0x53, 0xC5, 0x03, 0xC8, 0xDB, 0xBD, 0xDE, 0xA5, 0x20,
+	}
+)
+
+// CheckPasswordLeak reports whether the credentials are known to have leaked.
+// Only a 26-bit prefix of the username hash and the credentials hash,
+// encrypted with a key that never leaves the process, are sent to Google.
+func (c *client) CheckPasswordLeak(ctx context.Context, username, password string) (bool, error) {
+	if username == "" || password == "" {
+		return false, ErrInvalidCredentials
+	}
+
+	if c.config.MockMode {
+		return password == "leaked_password", nil
+	}
+
+	verification, err := newPasswordLeakVerification(username, password)
+	if err != nil {
+		return false, err
+	}
+
+	request := &recaptchapb.CreateAssessmentRequest{
+		Assessment: &recaptchapb.Assessment{
+			PrivatePasswordLeakVerification: &recaptchapb.PrivatePasswordLeakVerification{
+				LookupHashPrefix:             verification.lookupHashPrefix,
+				EncryptedUserCredentialsHash: verification.encryptedCredentialsHash,
+			},
+		},
+		Parent: fmt.Sprintf("projects/%s", c.config.ProjectID),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	response, err := c.client.CreateAssessment(ctx, request)
+	if err != nil {
+		return false, fmt.Errorf("failed to create assessment: %w", err)
+	}
+
+	leak := response.GetPrivatePasswordLeakVerification()
+	return verification.leaked(leak.GetReencryptedUserCredentialsHash(), leak.GetEncryptedLeakMatchPrefixes())
+}
+
+// passwordLeakVerification holds the client side of a password leak check:
+// the hashes sent to Google and the cipher that decrypts its answer. It ports
+// Google's recaptcha-password-check-helpers, which implement the client side
+// of Chrome's Password Checkup protocol.
+type passwordLeakVerification struct {
+	lookupHashPrefix         []byte
+	encryptedCredentialsHash []byte
+	cipher                   *commutativeCipher
+}
+
+// newPasswordLeakVerification hashes the credentials and encrypts the hash
+// with a fresh key
+func newPasswordLeakVerification(username, password string) (*passwordLeakVerification, error) {
+	canonical := canonicalizeUsername(username)
+
+	credentialsHash, err := hashCredentials(canonical, password)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := newCommutativeCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &passwordLeakVerification{
+		lookupHashPrefix:         usernameHashPrefix(canonical),
+		encryptedCredentialsHash: cipher.encrypt(credentialsHash),
+		cipher:                   cipher,
+	}, nil
+}
+
+// leaked removes this side's encryption from the credentials hash Google
+// re-encrypted with its own key, and reports whether the hash of the result
+// starts with any of the leaked credential prefixes Google returned
+func (v *passwordLeakVerification) leaked(reencryptedHash []byte, leakMatchPrefixes [][]byte) (bool, error) {
+	serverEncrypted, err := v.cipher.decrypt(reencryptedHash)
+	if err != nil {
+		return false, fmt.Errorf("invalid re-encrypted credentials hash: %w", err)
+	}
+	serverHash := sha256.Sum256(serverEncrypted)
+
+	for _, prefix := range leakMatchPrefixes {
+		if bytes.HasPrefix(serverHash[:], prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// canonicalizeUsername lowercases the username and strips the domain and
+// dots of email addresses, so variants of one account share a hash
+func canonicalizeUsername(username string) string {
+	canonical := strings.ToLower(username)
+	if at := strings.LastIndex(canonical, "@"); at >= 0 {
+		canonical = canonical[:at]
+	}
+	return strings.ReplaceAll(canonical, ".", "")
+}
+
+// hashUsername returns the salted SHA-256 hash of a canonical username
+func hashUsername(canonical string) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(canonical), usernameSalt...))
+}
+
+// usernameHashPrefix returns the first 26 bits of the username hash,
+// zero-padded to whole bytes
+func usernameHashPrefix(canonical string) []byte {
+	hash := hashUsername(canonical)
+
+	prefix := hash[:(usernameHashPrefixBits+7)/8]
+	prefix[len(prefix)-1] &^= 0xFF >> (usernameHashPrefixBits % 8)
+	return prefix
+}
+
+// hashCredentials returns the scrypt hash of a canonical username and
+// password, salted with the username
+func hashCredentials(canonical, password string) ([]byte, error) {
+	hash, err := scrypt.Key(
+		[]byte(canonical+password),
+		append([]byte(canonical), credentialsSalt...),
+		scryptCost, scryptBlockSize, scryptParallelism, scryptKeyLength,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash credentials: %w", err)
+	}
+	return hash, nil
+}
+
+// commutativeCipher is the P-256 commutative cipher of private-join-and-compute
+// (ECCommutativeCipher with SHA-256 hashing to the curve). Encrypting with
+// two keys gives the same point in either order, so Google can match
+// credentials without either side seeing the other's key.
+type commutativeCipher struct {
+	key *big.Int
+}
+
+// newCommutativeCipher creates a cipher with a random key in [1, n)
+func newCommutativeCipher() (*commutativeCipher, error) {
+	n := elliptic.P256().Params().N
+	key, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(1)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &commutativeCipher{key: key.Add(key, big.NewInt(1))}, nil
+}
+
+// encrypt hashes data to a curve point and multiplies it by the key,
+// returning the compressed point
+func (c *commutativeCipher) encrypt(data []byte) []byte {
+	x, y := hashToCurve(data)
+	return multiplyPoint(x, y, c.key)
+}
+
+// decrypt multiplies a compressed point by the inverse of the key
+func (c *commutativeCipher) decrypt(ciphertext []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, ciphertext)
+	if x == nil {
+		return nil, errors.New("not a compressed P-256 point")
+	}
+	return multiplyPoint(x, y, new(big.Int).ModInverse(c.key, curve.Params().N)), nil
+}
+
+// multiplyPoint multiplies a P-256 point by a scalar and returns it compressed
+func multiplyPoint(x, y, scalar *big.Int) []byte {
+	curve := elliptic.P256()
+	mx, my := curve.ScalarMult(x, y, scalar.Bytes())
+	return elliptic.MarshalCompressed(curve, mx, my)
+}
+
+// hashToCurve maps data to a P-256 point by try-and-increment, as
+// ECGroup::GetPointByHashingToCurveSha256: x is the random oracle of the
+// data, rehashed until x³ - 3x + b is a square, and y is its even root
+func hashToCurve(data []byte) (x, y *big.Int) {
+	params := elliptic.P256().Params()
+	three := big.NewInt(3)
+
+	x = randomOracle(data, params.P)
+	for {
+		y2 := new(big.Int).Exp(x, three, params.P)
+		y2.Sub(y2, new(big.Int).Mul(three, x))
+		y2.Add(y2, params.B)
+		y2.Mod(y2, params.P)
+
+		if y = new(big.Int).ModSqrt(y2, params.P); y != nil {
+			if y.Bit(0) == 1 {
+				y.Sub(params.P, y)
+			}
+			return x, y
+		}
+		x = randomOracle(x.Bytes(), params.P)
+	}
+}
+
+// randomOracle hashes data to an integer below max as Context::RandomOracle:
+// counter-prefixed SHA-256 blocks are concatenated to max's bit length plus
+// 256 bits, the excess bits are shifted out and the result is reduced
+func randomOracle(data []byte, max *big.Int) *big.Int {
+	const hashBits = sha256.Size * 8
+	outputBits := max.BitLen() + hashBits
+	blocks := (outputBits + hashBits - 1) / hashBits
+
+	output := new(big.Int)
+	for i := 1; i <= blocks; i++ {
+		hash := sha256.Sum256(append(big.NewInt(int64(i)).Bytes(), data...))
+		output.Lsh(output, hashBits)
+		output.Add(output, new(big.Int).SetBytes(hash[:]))
+	}
+	output.Rsh(output, uint(blocks*hashBits-outputBits))
+	return output.Mod(output, max)
+}
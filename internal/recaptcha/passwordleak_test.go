@@ -0,0 +1,211 @@
+package recaptcha
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCanonicalizeUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		expected string
+	}{
+		{username: "Citizen", expected: "citizen"},
+		{username: "First.Last@Example.com", expected: "firstlast"},
+		{username: "a@b@rio.rj.gov.br", expected: "a@b"},
+		{username: "no.domain", expected: "nodomain"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalizeUsername(tt.username); got != tt.expected {
+			t.Errorf("canonicalizeUsername(%q) = %q, want %q", tt.username, got, tt.expected)
+		}
+	}
+}
+
+// The username and credentials hash vectors are the ones of Chrome's
+// Password Checkup client, which the reCAPTCHA password check helpers share
+
+func TestHashUsername(t *testing.T) {
+	hash := hashUsername("jonsnow")
+
+	expected := mustDecodeHex(t, "3d70d37bfc1a3d8145e6c7a3a4d7927661c1e8df82bd0c9f619aa3c996ec4cb3")
+	if !bytes.Equal(hash[:], expected) {
+		t.Errorf("hashUsername(jonsnow) = %x, want %x", hash, expected)
+	}
+}
+
+func TestUsernameHashPrefix(t *testing.T) {
+	prefix := usernameHashPrefix("jonsnow")
+
+	expected := []byte{0x3D, 0x70, 0xD3, 0x40}
+	if !bytes.Equal(prefix, expected) {
+		t.Errorf("usernameHashPrefix(jonsnow) = %x, want %x", prefix, expected)
+	}
+}
+
+func TestHashCredentials(t *testing.T) {
+	hash, err := hashCredentials("user", "password123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []byte{
+		153, 126, 246, 118, 7, 76, 205, 180, 200, 174, 218, 31, 114, 61, 249, 103,
+		76, 91, 52, 204, 47, 234, 107, 77, 118, 123, 242, 131, 133, 85, 115, 253,
+	}
+	if !bytes.Equal(hash, expected) {
+		t.Errorf("hashCredentials(user, password123) = %v, want %v", hash, expected)
+	}
+}
+
+// The cipher vectors follow private-join-and-compute's construction
+// (Context::RandomOracle and ECGroup::GetPointByHashingToCurveSha256),
+// computed independently of this package
+
+func TestHashToCurve(t *testing.T) {
+	credentialsHash := []byte{
+		153, 126, 246, 118, 7, 76, 205, 180, 200, 174, 218, 31, 114, 61, 249, 103,
+		76, 91, 52, 204, 47, 234, 107, 77, 118, 123, 242, 131, 133, 85, 115, 253,
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "first candidate on the curve",
+			data:     credentialsHash,
+			expected: "020b6ae7fc2bbe811e8ba1e563d47cf326734e41f4a4b1c048fcd429e9a8fc5caa",
+		},
+		{
+			name:     "rehashed candidate",
+			data:     []byte("msg5"),
+			expected: "0256b4e66e13989c188107bd48e37a8ef7d91b7e800275818875aefbffb935c2f0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := hashToCurve(tt.data)
+			if !elliptic.P256().IsOnCurve(x, y) {
+				t.Fatalf("Expected point on P-256")
+			}
+
+			point := elliptic.MarshalCompressed(elliptic.P256(), x, y)
+			if !bytes.Equal(point, mustDecodeHex(t, tt.expected)) {
+				t.Errorf("hashToCurve = %x, want %s", point, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommutativeCipher(t *testing.T) {
+	credentialsHash, err := hashCredentials("user", "password123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	key, _ := new(big.Int).SetString("1d2c3b4a5968778695a4b3c2d1e0f00112233445566778899aabbccddeeff00", 16)
+	cipher := &commutativeCipher{key: key}
+
+	encrypted := cipher.encrypt(credentialsHash)
+	expected := mustDecodeHex(t, "03cc090ec47a40ea3289bb4cac1153732ea374662d22635a5c26f11c9815dbdf97")
+	if !bytes.Equal(encrypted, expected) {
+		t.Errorf("encrypt = %x, want %x", encrypted, expected)
+	}
+
+	decrypted, err := cipher.decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	x, y := hashToCurve(credentialsHash)
+	if !bytes.Equal(decrypted, elliptic.MarshalCompressed(elliptic.P256(), x, y)) {
+		t.Errorf("Expected decrypt to undo encrypt")
+	}
+
+	if _, err := cipher.decrypt([]byte("garbage")); err == nil {
+		t.Errorf("Expected error for an invalid point")
+	}
+}
+
+func TestPasswordLeakVerification_Leaked(t *testing.T) {
+	verification, err := newPasswordLeakVerification("User@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(verification.lookupHashPrefix, usernameHashPrefix("user")) {
+		t.Errorf("Unexpected lookup hash prefix")
+	}
+
+	// Play the server: re-encrypt the client's hash with the server key.
+	// The server's leak prefixes are hashes of leaked credentials encrypted
+	// with its key alone.
+	serverKey := big.NewInt(0x0fedcba987654321)
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), verification.encryptedCredentialsHash)
+	if x == nil {
+		t.Fatalf("Expected a compressed P-256 point")
+	}
+	reencrypted := multiplyPoint(x, y, serverKey)
+	serverHash := mustDecodeHex(t, "e9d7271f8c63a4ce6598b72400cc664ee0520d488aca665de66889dad440606d")
+
+	tests := []struct {
+		name     string
+		prefixes [][]byte
+		expected bool
+	}{
+		{name: "leaked", prefixes: [][]byte{{0x00}, serverHash[:5]}, expected: true},
+		{name: "not leaked", prefixes: [][]byte{{serverHash[0] ^ 0xFF}}, expected: false},
+		{name: "no prefixes", prefixes: nil, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaked, err := verification.leaked(reencrypted, tt.prefixes)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if leaked != tt.expected {
+				t.Errorf("Expected leaked=%v, got %v", tt.expected, leaked)
+			}
+		})
+	}
+
+	if _, err := verification.leaked([]byte("garbage"), nil); err == nil {
+		t.Errorf("Expected error for an invalid re-encrypted hash")
+	}
+}
+
+func TestClient_CheckPasswordLeak_Mock(t *testing.T) {
+	client := &client{config: &Config{MockMode: true, Timeout: 5 * time.Second}}
+
+	leaked, err := client.CheckPasswordLeak(context.Background(), "citizen", "leaked_password")
+	if err != nil || !leaked {
+		t.Errorf("Expected leaked credentials, got leaked=%v err=%v", leaked, err)
+	}
+
+	leaked, err = client.CheckPasswordLeak(context.Background(), "citizen", "correct horse")
+	if err != nil || leaked {
+		t.Errorf("Expected safe credentials, got leaked=%v err=%v", leaked, err)
+	}
+
+	if _, err := client.CheckPasswordLeak(context.Background(), "citizen", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatalf("Invalid hex %q: %v", value, err)
+	}
+	return data
+}
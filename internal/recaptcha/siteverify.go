@@ -0,0 +1,163 @@
+package recaptcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultSiteverifyURL is Google's legacy reCAPTCHA verification endpoint
+const DefaultSiteverifyURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// siteverifyResponse is the JSON body returned by the siteverify endpoint
+type siteverifyResponse struct {
+	Success        bool     `json:"success"`
+	Score          float64  `json:"score"`
+	Action         string   `json:"action"`
+	ChallengeTS    string   `json:"challenge_ts"`
+	Hostname       string   `json:"hostname"`
+	APKPackageName string   `json:"apk_package_name"`
+	ErrorCodes     []string `json:"error-codes"`
+}
+
+// siteverifyClient implements the Client interface for classic reCAPTCHA
+// keys, verified with a secret key instead of a GCP Enterprise project
+type siteverifyClient struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+// NewSiteverifyClient creates a new legacy siteverify reCAPTCHA client
+func NewSiteverifyClient(config *Config) Client {
+	return &siteverifyClient{
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Validate validates a reCAPTCHA token against the siteverify endpoint
+func (c *siteverifyClient) Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	token := req.Token
+	if c.config.MockMode {
+		return mockValidation(c.config, token)
+	}
+
+	if token == "" {
+		return &ValidationResult{
+			Success:    false,
+			ErrorCodes: []string{"missing-input-response"},
+		}, nil
+	}
+
+	// Verify token
+	form := url.Values{
+		"secret":   {c.config.SecretKey},
+		"response": {token},
+	}
+	if req.UserIPAddress != "" {
+		form.Set("remoteip", req.UserIPAddress)
+	}
+
+	var response siteverifyResponse
+	if err := postVerify(ctx, c.httpClient, c.verifyURL(), form, &response); err != nil {
+		return nil, err
+	}
+
+	// v2 keys carry no action or score, so only v3 responses are judged on them
+	return c.config.judge(req, &response, response.Action != ""), nil
+}
+
+// verifyURL returns the configured siteverify URL or Google's default
+func (c *siteverifyClient) verifyURL() string {
+	if c.config.SiteverifyURL != "" {
+		return c.config.SiteverifyURL
+	}
+	return DefaultSiteverifyURL
+}
+
+// judge turns a siteverify-style response into a ValidationResult, checking
+// the origin allow-lists, the action when one is reported and, if scored,
+// the score threshold
+func (c *Config) judge(req *ValidationRequest, response *siteverifyResponse, scored bool) *ValidationResult {
+	if !response.Success {
+		errorCodes := response.ErrorCodes
+		if len(errorCodes) == 0 {
+			errorCodes = []string{"invalid-input-response"}
+		}
+
+		return &ValidationResult{
+			Success:    false,
+			Hostname:   response.Hostname,
+			ErrorCodes: errorCodes,
+		}
+	}
+
+	// Check where the token was solved
+	if response.Hostname != "" && !matchAllowList(response.Hostname, c.AllowedHostnames) {
+		return &ValidationResult{
+			Success:    false,
+			Hostname:   response.Hostname,
+			ErrorCodes: []string{"hostname-mismatch"},
+		}
+	}
+	if response.APKPackageName != "" && !matchAllowList(response.APKPackageName, c.AllowedAndroidPackages) {
+		return &ValidationResult{
+			Success:    false,
+			ErrorCodes: []string{"android-package-mismatch"},
+		}
+	}
+
+	if response.Action != "" && !c.actionAllowed(req, response.Action) {
+		return &ValidationResult{
+			Success:    false,
+			Action:     response.Action,
+			ErrorCodes: []string{"action-mismatch"},
+		}
+	}
+
+	result := &ValidationResult{
+		Success:     true,
+		Score:       response.Score,
+		Scored:      scored,
+		Action:      response.Action,
+		ChallengeTS: response.ChallengeTS,
+		Hostname:    response.Hostname,
+		ErrorCodes:  []string{},
+	}
+
+	if scored && response.Score < c.threshold(req) {
+		result.Success = false
+		result.ErrorCodes = append(result.ErrorCodes, "score-below-threshold")
+	}
+
+	return result
+}
+
+// postVerify posts a verification form to url and decodes the JSON response
+// into v
+func postVerify(ctx context.Context, httpClient *http.Client, verifyURL string, form url.Values, v interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create verify request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to call verify endpoint: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verify endpoint returned status %d", httpResp.StatusCode)
+	}
+
+	if err := json.NewDecoder(httpResp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode verify response: %w", err)
+	}
+
+	return nil
+}
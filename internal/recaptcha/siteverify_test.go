@@ -0,0 +1,277 @@
+package recaptcha
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newSiteverifyServer(t *testing.T, responses map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if got := r.FormValue("secret"); got != "test_secret" {
+			t.Errorf("Expected secret=test_secret, got %q", got)
+		}
+
+		response, ok := responses[r.FormValue("response")]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestSiteverifyClient_Validate(t *testing.T) {
+	server := newSiteverifyServer(t, map[string]interface{}{
+		"v3_token": siteverifyResponse{
+			Success:     true,
+			Score:       0.9,
+			Action:      "authz",
+			ChallengeTS: "2024-01-01T00:00:00Z",
+			Hostname:    "www.rio.rj.gov.br",
+		},
+		"v2_token": siteverifyResponse{
+			Success:  true,
+			Hostname: "www.rio.rj.gov.br",
+		},
+		"low_score_token": siteverifyResponse{
+			Success:  true,
+			Score:    0.1,
+			Action:   "authz",
+			Hostname: "www.rio.rj.gov.br",
+		},
+		"zero_score_token": siteverifyResponse{
+			Success:  true,
+			Score:    0.0,
+			Action:   "authz",
+			Hostname: "www.rio.rj.gov.br",
+		},
+		"wrong_action_token": siteverifyResponse{
+			Success:  true,
+			Score:    0.9,
+			Action:   "login",
+			Hostname: "www.rio.rj.gov.br",
+		},
+		"wrong_hostname_token": siteverifyResponse{
+			Success:  true,
+			Score:    0.9,
+			Action:   "authz",
+			Hostname: "evil.example.net",
+		},
+		"duplicate_token": siteverifyResponse{
+			Success:    false,
+			ErrorCodes: []string{"timeout-or-duplicate"},
+		},
+	})
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedValid  bool
+		expectedScore  float64
+		expectedScored bool
+		expectedCode   string
+		expectedError  bool
+	}{
+		{
+			name:           "valid v3 token",
+			token:          "v3_token",
+			expectedValid:  true,
+			expectedScore:  0.9,
+			expectedScored: true,
+		},
+		{
+			name:          "valid v2 token",
+			token:         "v2_token",
+			expectedValid: true,
+		},
+		{
+			name:           "low score token",
+			token:          "low_score_token",
+			expectedValid:  false,
+			expectedScore:  0.1,
+			expectedScored: true,
+			expectedCode:   "score-below-threshold",
+		},
+		{
+			name:           "zero score token",
+			token:          "zero_score_token",
+			expectedValid:  false,
+			expectedScored: true,
+			expectedCode:   "score-below-threshold",
+		},
+		{
+			name:          "action mismatch",
+			token:         "wrong_action_token",
+			expectedValid: false,
+			expectedCode:  "action-mismatch",
+		},
+		{
+			name:          "hostname mismatch",
+			token:         "wrong_hostname_token",
+			expectedValid: false,
+			expectedCode:  "hostname-mismatch",
+		},
+		{
+			name:          "error codes",
+			token:         "duplicate_token",
+			expectedValid: false,
+			expectedCode:  "timeout-or-duplicate",
+		},
+		{
+			name:          "empty token",
+			token:         "",
+			expectedValid: false,
+			expectedCode:  "missing-input-response",
+		},
+		{
+			name:          "server error",
+			token:         "unknown_token",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				SiteKey:          "test_site_key",
+				Action:           "authz",
+				V3Threshold:      0.5,
+				Timeout:          5 * time.Second,
+				SecretKey:        "test_secret",
+				SiteverifyURL:    server.URL,
+				AllowedHostnames: []string{"*.rio.rj.gov.br"},
+			}
+
+			client := NewSiteverifyClient(config)
+			result, err := client.Validate(context.Background(), &ValidationRequest{Token: tt.token})
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if result.IsValidToken() != tt.expectedValid {
+				t.Errorf("Expected valid=%v, got %v", tt.expectedValid, result.IsValidToken())
+			}
+
+			if tt.expectedScore != 0 && result.Score != tt.expectedScore {
+				t.Errorf("Expected score=%v, got %v", tt.expectedScore, result.Score)
+			}
+
+			if result.Scored != tt.expectedScored {
+				t.Errorf("Expected scored=%v, got %v", tt.expectedScored, result.Scored)
+			}
+
+			if tt.expectedCode != "" && result.GetErrorCodes() != tt.expectedCode {
+				t.Errorf("Expected error code %v, got %v", tt.expectedCode, result.GetErrorCodes())
+			}
+		})
+	}
+}
+
+func TestTurnstileClient_Validate(t *testing.T) {
+	server := newSiteverifyServer(t, map[string]interface{}{
+		"valid_token": siteverifyResponse{
+			Success:  true,
+			Hostname: "www.rio.rj.gov.br",
+		},
+		"duplicate_token": siteverifyResponse{
+			Success:    false,
+			ErrorCodes: []string{"timeout-or-duplicate"},
+		},
+	})
+
+	config := &Config{
+		Action:        "authz",
+		V3Threshold:   0.5,
+		Timeout:       5 * time.Second,
+		SecretKey:     "test_secret",
+		SiteverifyURL: server.URL,
+	}
+	client := NewTurnstileClient(config)
+
+	// Turnstile reports no score, so a successful token passes any threshold
+	result, err := client.Validate(context.Background(), &ValidationRequest{Token: "valid_token"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.IsValidToken() {
+		t.Errorf("Expected valid token, got %v", result)
+	}
+
+	result, err = client.Validate(context.Background(), &ValidationRequest{Token: "duplicate_token"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.IsValidToken() || result.GetErrorCodes() != "timeout-or-duplicate" {
+		t.Errorf("Expected timeout-or-duplicate, got %v", result)
+	}
+}
+
+func TestHCaptchaClient_Validate(t *testing.T) {
+	human, bot := 0.1, 0.8
+
+	server := newSiteverifyServer(t, map[string]interface{}{
+		"human_token": hcaptchaResponse{Success: true, Score: &human},
+		"bot_token":   hcaptchaResponse{Success: true, Score: &bot},
+		"unscored_token": hcaptchaResponse{
+			Success: true,
+		},
+	})
+
+	tests := []struct {
+		name          string
+		token         string
+		expectedValid bool
+		expectedScore float64
+	}{
+		{name: "human", token: "human_token", expectedValid: true, expectedScore: 0.9},
+		{name: "bot", token: "bot_token", expectedValid: false, expectedScore: 0.2},
+		{name: "unscored", token: "unscored_token", expectedValid: true, expectedScore: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				V3Threshold:   0.5,
+				Timeout:       5 * time.Second,
+				SecretKey:     "test_secret",
+				SiteverifyURL: server.URL,
+			}
+
+			result, err := NewHCaptchaClient(config).Validate(context.Background(), &ValidationRequest{Token: tt.token})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if result.IsValidToken() != tt.expectedValid {
+				t.Errorf("Expected valid=%v, got %v", tt.expectedValid, result.IsValidToken())
+			}
+
+			if math.Abs(result.Score-tt.expectedScore) > 1e-9 {
+				t.Errorf("Expected score=%v, got %v", tt.expectedScore, result.Score)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package recaptcha
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// DefaultTurnstileURL is Cloudflare Turnstile's verification endpoint
+const DefaultTurnstileURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+// turnstileClient implements the Client interface for Cloudflare Turnstile
+type turnstileClient struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+// NewTurnstileClient creates a new Cloudflare Turnstile client
+func NewTurnstileClient(config *Config) Client {
+	return &turnstileClient{
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Validate validates a Turnstile token. Turnstile reports no score, so only
+// success, the hostname and the action (when the widget sets one) are judged.
+func (c *turnstileClient) Validate(ctx context.Context, req *ValidationRequest) (*ValidationResult, error) {
+	token := req.Token
+	if c.config.MockMode {
+		return mockValidation(c.config, token)
+	}
+
+	if token == "" {
+		return &ValidationResult{
+			Success:    false,
+			ErrorCodes: []string{"missing-input-response"},
+		}, nil
+	}
+
+	verifyURL := c.config.SiteverifyURL
+	if verifyURL == "" {
+		verifyURL = DefaultTurnstileURL
+	}
+
+	form := url.Values{
+		"secret":   {c.config.SecretKey},
+		"response": {token},
+	}
+	if req.UserIPAddress != "" {
+		form.Set("remoteip", req.UserIPAddress)
+	}
+
+	var response siteverifyResponse
+	if err := postVerify(ctx, c.httpClient, verifyURL, form, &response); err != nil {
+		return nil, err
+	}
+
+	return c.config.judge(req, &response, false), nil
+}
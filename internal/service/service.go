@@ -2,8 +2,15 @@ package service
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prefeitura-rio/app-ext-authz/internal/cache"
@@ -12,22 +19,83 @@ import (
 	"github.com/prefeitura-rio/app-ext-authz/internal/observability"
 	"github.com/prefeitura-rio/app-ext-authz/internal/recaptcha"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+// tokenLifetime is how long Google accepts a reCAPTCHA token after it is
+// generated
+const tokenLifetime = 2 * time.Minute
+
+// chainProvider names the composite provider chain in labels and cache keys
+const chainProvider = "chain"
+
+// coalescePollInterval is how often a pod waiting on another pod's
+// validation checks the cache for its result
+const coalescePollInterval = 50 * time.Millisecond
+
+// ErrAnnotationUnsupported is returned when the reCAPTCHA backend cannot
+// annotate assessments
+var ErrAnnotationUnsupported = errors.New("assessment annotation requires the reCAPTCHA Enterprise backend")
+
+// ErrPasswordLeakUnsupported is returned when the reCAPTCHA backend cannot
+// check credentials for leaks
+var ErrPasswordLeakUnsupported = errors.New("password leak checks require the reCAPTCHA Enterprise backend")
+
+// ErrCircuitOpen is returned when the circuit breaker rejects a call
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrUnknownSelector is returned for a strict request naming a policy or
+// site key that is not configured
+var ErrUnknownSelector = errors.New("unknown policy or site key")
+
 // Service handles authorization requests
 type Service struct {
 	config         *config.Config
-	recaptchaClient recaptcha.Client
+	clients        map[string]recaptcha.Client // Keyed by provider name
+	chainBreakers  map[string]*circuitbreaker.Breaker
 	cache          cache.Cache
 	circuitBreaker *circuitbreaker.Breaker
 	telemetry      *observability.Telemetry
 	metrics        *observability.Metrics
+	scores         *observability.ScoreWindow
+	inflight       singleflight.Group // In-flight validations by cache key
 }
 
 // AuthorizationRequest represents an authorization request
 type AuthorizationRequest struct {
 	Token string `json:"token"`
+
+	// Original request as seen by the proxy, for route-aware decisions
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// Policy selects a named policy; empty uses the default policy
+	Policy string `json:"policy,omitempty"`
+
+	// SiteKey selects a site key by name or key value; empty uses the default key
+	SiteKey string `json:"site_key,omitempty"`
+
+	// StrictSelectors rejects an unknown policy or site key with
+	// ErrUnknownSelector instead of using the default
+	StrictSelectors bool `json:"-"`
+
+	// Provider names the CAPTCHA provider that issued the token; empty is reCAPTCHA
+	Provider string `json:"provider,omitempty"`
+
+	// Tokens holds every token present by provider, for the provider chain
+	Tokens map[string]string `json:"-"`
+
+	// Client signals forwarded to the provider with the token
+	ClientIP     string `json:"client_ip,omitempty"`
+	UserAgent    string `json:"user_agent,omitempty"`
+	JA3          string `json:"ja3,omitempty"`
+	RequestedURI string `json:"requested_uri,omitempty"`
+
+	// AccountID identifies the account for Account Defender. It is only
+	// sent, cached and logged as an HMAC.
+	AccountID string `json:"-"`
 }
 
 // AuthorizationResponse represents an authorization response
@@ -36,6 +104,40 @@ type AuthorizationResponse struct {
 	Status  string `json:"status"`
 	Score   string `json:"score,omitempty"`
 	Cache   string `json:"cache,omitempty"`
+
+	// Provider that decided; several are comma-separated for all_of chains
+	Provider string `json:"provider,omitempty"`
+
+	// Assessment resource name, for later annotation
+	Assessment string `json:"assessment,omitempty"`
+
+	// Risk analysis reasons and extended verdict reasons
+	Reasons []string `json:"reasons,omitempty"`
+
+	// Account Defender labels
+	AccountLabels []string `json:"account_labels,omitempty"`
+
+	// Decision a shadow policy would have made: "allow" or "deny"
+	ShadowDecision string `json:"shadow_decision,omitempty"`
+
+	// Score band decision: the risk level and the HTTP status to answer
+	// with; 0 leaves the status to the handler
+	RiskLevel  string `json:"risk_level,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+// Risk levels of the score bands
+const (
+	RiskLevelLow    = "low"
+	RiskLevelMedium = "medium"
+	RiskLevelHigh   = "high"
+)
+
+// AnnotationRequest labels a past assessment as legitimate or fraudulent
+type AnnotationRequest struct {
+	Assessment string   `json:"assessment"`
+	Annotation string   `json:"annotation"`
+	Reasons    []string `json:"reasons,omitempty"`
 }
 
 // NewService creates a new authorization service
@@ -46,18 +148,73 @@ func NewService(cfg *config.Config) (*Service, error) {
 		SiteKey:     cfg.RecaptchaSiteKey,
 		Action:      cfg.RecaptchaAction,
 		V3Threshold: cfg.RecaptchaV3Threshold,
-		Timeout:     time.Duration(cfg.GoogleAPITimeoutSeconds) * time.Second,
+		Timeout:     cfg.ProviderTimeout(recaptcha.ProviderRecaptcha),
 		MockMode:    cfg.MockMode,
+
+		SecretKey:     cfg.RecaptchaSecretKey,
+		SiteverifyURL: cfg.RecaptchaSiteverifyURL,
+
+		AllowedHostnames:       cfg.AllowedHostnames,
+		AllowedAndroidPackages: cfg.AllowedAndroidPackages,
+		AllowedIOSBundleIDs:    cfg.AllowedIOSBundleIDs,
+	}
+	var recaptchaClient recaptcha.Client
+	if cfg.RecaptchaBackend == "siteverify" {
+		recaptchaClient = recaptcha.NewSiteverifyClient(recaptchaConfig)
+	} else {
+		recaptchaClient = recaptcha.NewClient(recaptchaConfig)
+	}
+	clients := map[string]recaptcha.Client{recaptcha.ProviderRecaptcha: recaptchaClient}
+
+	// Create alternative provider clients, sharing the expectations and
+	// allow-lists of the reCAPTCHA client
+	if cfg.TurnstileSecretKey != "" {
+		turnstileConfig := *recaptchaConfig
+		turnstileConfig.SiteKey = ""
+		turnstileConfig.SecretKey = cfg.TurnstileSecretKey
+		turnstileConfig.SiteverifyURL = cfg.TurnstileVerifyURL
+		turnstileConfig.Timeout = cfg.ProviderTimeout(recaptcha.ProviderTurnstile)
+		clients[recaptcha.ProviderTurnstile] = recaptcha.NewTurnstileClient(&turnstileConfig)
+	}
+
+	if cfg.HCaptchaSecretKey != "" {
+		hcaptchaConfig := *recaptchaConfig
+		hcaptchaConfig.SiteKey = cfg.HCaptchaSiteKey
+		hcaptchaConfig.SecretKey = cfg.HCaptchaSecretKey
+		hcaptchaConfig.SiteverifyURL = cfg.HCaptchaVerifyURL
+		hcaptchaConfig.Timeout = cfg.ProviderTimeout(recaptcha.ProviderHCaptcha)
+		clients[recaptcha.ProviderHCaptcha] = recaptcha.NewHCaptchaClient(&hcaptchaConfig)
 	}
-	recaptchaClient := recaptcha.NewClient(recaptchaConfig)
 
 	// Create cache
 	cacheConfig := cache.Config{
-		Type:          "redis",
+		Type:          cfg.CacheType,
 		RedisURL:      cfg.RedisURL,
 		DefaultTTL:    time.Duration(cfg.CacheTTLSeconds) * time.Second,
 		FailedTTL:     time.Duration(cfg.CacheFailedTTLSeconds) * time.Second,
-		MaxMemorySize: 10000, // Not used for Redis
+		MaxMemorySize: cfg.CacheLocalMaxItems,
+		LocalTTL:      cfg.CacheLocalTTL,
+		KeyPrefix:     cfg.RedisNamespace(),
+
+		OperationTimeout:        cfg.RedisOperationTimeout,
+		BreakerFailureThreshold: cfg.RedisBreakerFailureThreshold,
+		BreakerRecoveryTime:     cfg.RedisBreakerRecoveryTime,
+
+		RedisMode:             cfg.RedisMode,
+		RedisAddrs:            cfg.RedisAddrs,
+		RedisMasterName:       cfg.RedisMasterName,
+		RedisSentinelPassword: cfg.RedisSentinelPassword,
+		RedisDB:               cfg.RedisDB,
+		RedisUsername:         cfg.RedisUsername,
+		RedisPassword:         cfg.RedisPassword,
+		RedisTLS:              cfg.RedisTLS,
+		RedisTLSCAFile:        cfg.RedisTLSCAFile,
+		RedisTLSServerName:    cfg.RedisTLSServerName,
+		RedisPoolSize:         cfg.RedisPoolSize,
+		RedisMinIdleConns:     cfg.RedisMinIdleConns,
+		RedisReadTimeout:      cfg.RedisReadTimeout,
+		RedisWriteTimeout:     cfg.RedisWriteTimeout,
+		RedisReadFromReplicas: cfg.RedisReadFromReplicas,
 	}
 	cacheInstance, err := cache.NewCache(cacheConfig)
 	if err != nil {
@@ -72,11 +229,32 @@ func NewService(cfg *config.Config) (*Service, error) {
 	}
 	circuitBreaker := circuitbreaker.NewBreaker(circuitBreakerConfig)
 
+	// Create the provider chain, with a breaker per provider in place of the
+	// service-wide one
+	var chainBreakers map[string]*circuitbreaker.Breaker
+	if len(cfg.ProviderChain) > 0 {
+		chainBreakers = make(map[string]*circuitbreaker.Breaker)
+		members := make([]recaptcha.Member, 0, len(cfg.ProviderChain))
+		for _, provider := range cfg.ProviderChain {
+			member := recaptcha.Member{
+				Provider: provider,
+				Client:   clients[provider],
+				Timeout:  cfg.ProviderTimeout(provider),
+			}
+			if cfg.CircuitBreakerEnabled {
+				member.Breaker = circuitbreaker.NewBreaker(circuitBreakerConfig)
+				chainBreakers[provider] = member.Breaker
+			}
+			members = append(members, member)
+		}
+		clients[chainProvider] = recaptcha.NewCompositeClient(cfg.ProviderChainMode, members)
+	}
+
 	// Create telemetry
 	telemetryConfig := observability.Config{
 		ServiceName:    cfg.OTelServiceName,
 		ServiceVersion: "1.0.0",
-		Environment:    "production",
+		Environment:    cfg.Environment,
 		OTelEndpoint:   cfg.OTelEndpoint,
 		LogLevel:       cfg.LogLevel,
 	}
@@ -85,6 +263,12 @@ func NewService(cfg *config.Config) (*Service, error) {
 		return nil, fmt.Errorf("failed to create telemetry: %w", err)
 	}
 
+	// The cache starts degraded, and reconnects in the background, when
+	// Redis is down
+	if cacheInstance.GetStats().Degraded {
+		telemetry.Logger.Warn("Redis is unavailable, starting with the cache bypassed")
+	}
+
 	// Create metrics
 	var metrics *observability.Metrics
 	if telemetry.Meter != nil {
@@ -92,15 +276,23 @@ func NewService(cfg *config.Config) (*Service, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create metrics: %w", err)
 		}
+		err = observability.NewCacheDegradedGauge(telemetry.Meter, func() bool {
+			return cacheInstance.GetStats().Degraded
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metrics: %w", err)
+		}
 	}
 
 	return &Service{
 		config:         cfg,
-		recaptchaClient: recaptchaClient,
+		clients:        clients,
+		chainBreakers:  chainBreakers,
 		cache:          cacheInstance,
 		circuitBreaker: circuitBreaker,
 		telemetry:      telemetry,
 		metrics:        metrics,
+		scores:         observability.NewScoreWindow(cfg.ScoreWindow),
 	}, nil
 }
 
@@ -109,160 +301,643 @@ func (s *Service) Authorize(ctx context.Context, req *AuthorizationRequest) (*Au
 	startTime := time.Now()
 	requestID := generateRequestID()
 
+	// Resolve provider, site key and policy. A configured provider chain
+	// handles every request.
+	provider := req.Provider
+	if len(s.config.ProviderChain) > 0 {
+		provider = chainProvider
+	} else if provider == "" {
+		provider = recaptcha.ProviderRecaptcha
+	}
+	client, ok := s.clients[provider]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not enabled", provider)
+	}
+
+	siteKeyName, siteKey := s.config.ResolveSiteKey(req.SiteKey)
+	if req.SiteKey != "" && siteKeyName != req.SiteKey && siteKey.Key != req.SiteKey {
+		if req.StrictSelectors {
+			s.telemetry.Logger.WithField("site_key", req.SiteKey).Warn("Unknown site key, rejecting request")
+			return nil, ErrUnknownSelector
+		}
+		s.telemetry.Logger.WithField("site_key", req.SiteKey).Warn("Unknown site key, using default site key")
+	}
+
+	policyName, policy := s.config.ResolvePolicy(req.Policy)
+	if req.Policy != "" && policyName != req.Policy {
+		if req.StrictSelectors {
+			s.telemetry.Logger.WithField("policy", req.Policy).Warn("Unknown policy, rejecting request")
+			return nil, ErrUnknownSelector
+		}
+		s.telemetry.Logger.WithField("policy", req.Policy).Warn("Unknown policy, using default policy")
+	}
+
+	// A route policy overrides the site key's expected actions and threshold
+	actions, threshold := siteKey.Actions, siteKey.Threshold
+	if policyName == req.Policy {
+		actions, threshold = policy.Actions, policy.Threshold
+	}
+
+	// With score bands, tokens down to the challenge band are valid and the
+	// bands decide
+	if policy.Bands != nil {
+		threshold = policy.Bands.Challenge
+	}
+
+	labels := metric.WithAttributes(
+		attribute.String("provider", provider),
+		attribute.String("site_key", siteKeyName),
+	)
+
 	// Create span for tracing
 	ctx, span := s.telemetry.Tracer.Start(ctx, "authorize",
 		trace.WithAttributes(
 			attribute.String("request_id", requestID),
 			attribute.Int("token_length", len(req.Token)),
+			attribute.String("http.original_method", req.Method),
+			attribute.String("http.original_path", req.Path),
+			attribute.String("captcha.provider", provider),
+			attribute.String("recaptcha.policy", policyName),
+			attribute.String("recaptcha.site_key", siteKeyName),
 		),
 	)
 	defer span.End()
 
 	// Record metrics
 	if s.metrics != nil {
-		s.metrics.RequestsTotal.Add(ctx, 1)
+		s.metrics.RequestsTotal.Add(ctx, 1, labels)
 		defer func() {
-			s.metrics.ResponseTime.Record(ctx, time.Since(startTime).Seconds())
+			s.metrics.ResponseTime.Record(ctx, time.Since(startTime).Seconds(), labels)
 		}()
 	}
 
-	// Check cache first
-	cacheKey := cache.GenerateCacheKey(req.Token)
+	// Reject replays of single-use tokens before the cache can serve them
+	if s.config.SingleUseTokens {
+		if response := s.checkTokenReuse(ctx, req.Token, policy.FailureMode); response != nil {
+			s.applyShadow(ctx, response, nil, policyName, policy, labels)
+			s.logRequest(requestID, req.Token, provider, siteKeyName, policyName, response, false, time.Since(startTime), nil)
+			return response, nil
+		}
+	}
+
+	// Account Defender identifies the account by an HMAC; the raw identifier
+	// goes no further
+	hashedAccountID := s.hashAccountID(req.AccountID)
+
+	// Check cache first. Results are judged against the provider, site key
+	// and policy, so the cache is scoped by all three, and by the account
+	// whose labels they carry.
+	scope := []string{provider, siteKeyName, policyName}
+	if hashedAccountID != nil {
+		scope = append(scope, hex.EncodeToString(hashedAccountID))
+	}
+	cacheKey := cache.GenerateCacheKey(s.cacheToken(req), scope...)
 			cachedResult, err := s.cache.Get(ctx, cacheKey)
 		if err == nil && cachedResult != nil {
 			// Cache hit
 			if s.metrics != nil {
-				s.metrics.CacheHits.Add(ctx, 1)
+				s.metrics.CacheHits.Add(ctx, 1, labels)
 			}
 
 			s.telemetry.LogCache("get", cacheKey, true, time.Since(startTime))
 
 			// Convert cache.ValidationResult to recaptcha.ValidationResult
-			recaptchaResult := s.convertCacheResult(cachedResult)
-			response := s.createResponse(recaptchaResult, "hit")
-			s.logRequest(requestID, req.Token, response.Status, true, time.Since(startTime), nil)
+			recaptchaResult := s.enforcePolicy(s.convertCacheResult(cachedResult), policy)
+			response := s.createResponse(recaptchaResult, "hit", policy.Bands)
+			s.recordScore(ctx, recaptchaResult, actions, siteKeyName, "hit")
+			s.applyShadow(ctx, response, recaptchaResult, policyName, policy, labels)
+			span.SetAttributes(
+				attribute.StringSlice("recaptcha.reasons", response.Reasons),
+				attribute.StringSlice("recaptcha.account_labels", response.AccountLabels),
+			)
+			s.logRequest(requestID, req.Token, provider, siteKeyName, policyName, response, true, time.Since(startTime), nil)
 			return response, nil
 		}
 
-	// Cache miss
+	// Cache miss, or the cache is unavailable
+	if err != nil && !errors.Is(err, cache.ErrCacheMiss) {
+		s.logCacheError(err, "Failed to get cached result")
+	}
 	if s.metrics != nil {
-		s.metrics.CacheMisses.Add(ctx, 1)
+		s.metrics.CacheMisses.Add(ctx, 1, labels)
 	}
 
 	s.telemetry.LogCache("get", cacheKey, false, time.Since(startTime))
 
-	// Check circuit breaker
-	if s.config.CircuitBreakerEnabled && s.circuitBreaker.IsOpen() {
-		// Circuit breaker is open, handle based on failure mode
-		response := s.handleCircuitBreakerOpen()
-		s.logRequest(requestID, req.Token, response.Status, false, time.Since(startTime), nil)
-		return response, nil
+	// The provider chain has a breaker per provider instead
+	useBreaker := s.config.CircuitBreakerEnabled && provider != chainProvider
+
+	// Validate with the provider. Site keys and account identifiers only
+	// apply to reCAPTCHA.
+	validationReq := &recaptcha.ValidationRequest{
+		Token:         req.Token,
+		Actions:       actions,
+		Threshold:     &threshold,
+		UserIPAddress: req.ClientIP,
+		UserAgent:     req.UserAgent,
+		JA3:           req.JA3,
+		RequestedURI:  req.RequestedURI,
+	}
+	if provider == chainProvider {
+		validationReq.Tokens = req.Tokens
+	}
+	if provider == recaptcha.ProviderRecaptcha || provider == chainProvider {
+		validationReq.SiteKey = siteKey.Key
+		validationReq.HashedAccountID = hashedAccountID
 	}
 
-	// Validate with Google API
-	var validationResult *recaptcha.ValidationResult
-	var validationErr error
+	validate := func(ctx context.Context) (*recaptcha.ValidationResult, error) {
+		var validationResult *recaptcha.ValidationResult
+		var validationErr error
 
-	if s.config.CircuitBreakerEnabled {
-		// Use circuit breaker
-		validationErr = s.circuitBreaker.Execute(ctx, func() error {
-			result, err := s.validateWithGoogle(ctx, req.Token)
-			if err != nil {
-				return err
-			}
-			validationResult = result
-			return nil
-		})
-	} else {
-		// Direct validation
-		validationResult, validationErr = s.validateWithGoogle(ctx, req.Token)
+		if useBreaker {
+			// Use circuit breaker
+			validationErr = s.circuitBreaker.Execute(ctx, func() error {
+				result, err := s.validateWithProvider(ctx, client, validationReq, labels)
+				if err != nil {
+					return err
+				}
+				validationResult = result
+				return nil
+			})
+		} else {
+			// Direct validation
+			validationResult, validationErr = s.validateWithProvider(ctx, client, validationReq, labels)
+		}
+		if validationErr != nil {
+			return nil, validationErr
+		}
+
+		// Record the deciding provider, reject stale tokens, denied risk
+		// reasons and denied account labels, and cache the result
+		if validationResult.Provider == "" {
+			validationResult.Provider = provider
+		}
+		validationResult = s.enforcePolicy(validationResult, policy)
+		s.cacheResult(ctx, cacheKey, validationResult)
+		return validationResult, nil
+	}
+
+	// Concurrent requests with the same token share one validation
+	validationResult, validationErr := s.coalesce(ctx, cacheKey, provider, policy, labels, validate)
+
+	// The breaker rejected the call; it lets a probe through once the
+	// recovery time has passed
+	if errors.Is(validationErr, circuitbreaker.ErrOpen) {
+		response := s.handleCircuitBreakerOpen(policy.FailureMode)
+		s.applyShadow(ctx, response, nil, policyName, policy, labels)
+		s.logRequest(requestID, req.Token, provider, siteKeyName, policyName, response, false, time.Since(startTime), nil)
+		return response, nil
 	}
 
 	// Handle validation result
 	if validationErr != nil {
 		// Validation failed
 		if s.metrics != nil {
-			s.metrics.ErrorsTotal.Add(ctx, 1)
+			s.metrics.ErrorsTotal.Add(ctx, 1, labels)
 		}
 
-		response := s.handleValidationError(validationErr)
-		s.logRequest(requestID, req.Token, response.Status, false, time.Since(startTime), validationErr)
+		response := s.handleValidationError(validationErr, policy.FailureMode)
+		s.applyShadow(ctx, response, nil, policyName, policy, labels)
+		s.logRequest(requestID, req.Token, provider, siteKeyName, policyName, response, false, time.Since(startTime), validationErr)
 		return response, nil
 	}
 
-	// Cache the result
-	s.cacheResult(ctx, cacheKey, validationResult)
-
 	// Create response
-	response := s.createResponse(validationResult, "miss")
-	s.logRequest(requestID, req.Token, response.Status, false, time.Since(startTime), nil)
+	response := s.createResponse(validationResult, "miss", policy.Bands)
+	s.recordScore(ctx, validationResult, actions, siteKeyName, "miss")
+	s.applyShadow(ctx, response, validationResult, policyName, policy, labels)
+	span.SetAttributes(
+		attribute.StringSlice("recaptcha.reasons", response.Reasons),
+		attribute.StringSlice("recaptcha.account_labels", response.AccountLabels),
+	)
+	s.logRequest(requestID, req.Token, provider, siteKeyName, policyName, response, false, time.Since(startTime), nil)
 
 	return response, nil
 }
 
-// validateWithGoogle validates the token with Google's reCAPTCHA API
-func (s *Service) validateWithGoogle(ctx context.Context, token string) (*recaptcha.ValidationResult, error) {
-	ctx, span := s.telemetry.Tracer.Start(ctx, "validate_with_google")
+// Annotate sends a legitimate/fraudulent label for a past assessment to
+// reCAPTCHA Enterprise, through the same circuit breaker as validation
+func (s *Service) Annotate(ctx context.Context, req *AnnotationRequest) error {
+	annotator, ok := s.clients[recaptcha.ProviderRecaptcha].(recaptcha.Annotator)
+	if !ok {
+		return ErrAnnotationUnsupported
+	}
+
+	ctx, span := s.telemetry.Tracer.Start(ctx, "annotate_assessment",
+		trace.WithAttributes(
+			attribute.String("recaptcha.annotation", req.Annotation),
+			attribute.StringSlice("recaptcha.annotation_reasons", req.Reasons),
+		),
+	)
+	defer span.End()
+
+	annotationReq := &recaptcha.AnnotationRequest{
+		Assessment: req.Assessment,
+		Annotation: req.Annotation,
+		Reasons:    req.Reasons,
+	}
+
+	// Reject malformed requests before they count against the breaker
+	if err := annotationReq.Check(); err != nil {
+		return err
+	}
+
+	err := s.callRecaptcha(ctx, func() error {
+		return annotator.Annotate(ctx, annotationReq)
+	})
+
+	logFields := map[string]interface{}{
+		"annotation": req.Annotation,
+		"reasons":    req.Reasons,
+	}
+	if err != nil {
+		s.telemetry.Logger.WithFields(logFields).WithError(err).Warn("Failed to annotate assessment")
+		return err
+	}
+
+	s.telemetry.Logger.WithFields(logFields).Info("Assessment annotated")
+	return nil
+}
+
+// CheckPasswordLeak reports whether the credentials appear in reCAPTCHA
+// Enterprise's database of leaked credentials, through the same circuit
+// breaker as validation. The credentials are never logged.
+func (s *Service) CheckPasswordLeak(ctx context.Context, username, password string) (bool, error) {
+	checker, ok := s.clients[recaptcha.ProviderRecaptcha].(recaptcha.LeakChecker)
+	if !ok {
+		return false, ErrPasswordLeakUnsupported
+	}
+
+	// Reject malformed requests before they count against the breaker
+	if username == "" || password == "" {
+		return false, recaptcha.ErrInvalidCredentials
+	}
+
+	ctx, span := s.telemetry.Tracer.Start(ctx, "password_leak_check")
 	defer span.End()
 
 	startTime := time.Now()
-	result, err := s.recaptchaClient.Validate(ctx, token)
+	var leaked bool
+	err := s.callRecaptcha(ctx, func() error {
+		var err error
+		leaked, err = checker.CheckPasswordLeak(ctx, username, password)
+		return err
+	})
+
+	logFields := map[string]interface{}{
+		"response_time_ms": time.Since(startTime).Milliseconds(),
+	}
+	if err != nil {
+		s.telemetry.Logger.WithFields(logFields).WithError(err).Warn("Failed to check password leak")
+		return false, err
+	}
+
+	span.SetAttributes(attribute.Bool("recaptcha.password_leaked", leaked))
+	logFields["leaked"] = leaked
+	s.telemetry.Logger.WithFields(logFields).Info("Password leak checked")
+	return leaked, nil
+}
+
+// callRecaptcha calls reCAPTCHA Enterprise outside of validation through
+// reCAPTCHA's breaker: its chain breaker, or the service-wide one
+func (s *Service) callRecaptcha(ctx context.Context, call func() error) error {
+	breaker := s.chainBreakers[recaptcha.ProviderRecaptcha]
+	if breaker == nil && s.config.CircuitBreakerEnabled && len(s.config.ProviderChain) == 0 {
+		breaker = s.circuitBreaker
+	}
+
+	if breaker == nil {
+		return call()
+	}
+	err := breaker.Execute(ctx, call)
+	if errors.Is(err, circuitbreaker.ErrOpen) {
+		return ErrCircuitOpen
+	}
+	return err
+}
+
+// validateWithProvider validates the token with the provider's API
+func (s *Service) validateWithProvider(ctx context.Context, client recaptcha.Client, req *recaptcha.ValidationRequest, labels metric.MeasurementOption) (*recaptcha.ValidationResult, error) {
+	ctx, span := s.telemetry.Tracer.Start(ctx, "validate_with_provider")
+	defer span.End()
+
+	startTime := time.Now()
+	result, err := client.Validate(ctx, req)
 	duration := time.Since(startTime)
 
 	// Record metrics
 	if s.metrics != nil {
-		s.metrics.GoogleAPIDuration.Record(ctx, duration.Seconds())
+		s.metrics.GoogleAPIDuration.Record(ctx, duration.Seconds(), labels)
 		if err == nil && result.IsValidToken() {
-			s.metrics.ValidationSuccess.Add(ctx, 1)
+			s.metrics.ValidationSuccess.Add(ctx, 1, labels)
 		} else {
-			s.metrics.ValidationFailure.Add(ctx, 1)
+			s.metrics.ValidationFailure.Add(ctx, 1, labels)
 		}
 	}
 
-	// Log validation
-	s.telemetry.LogValidation(
-		"", // requestID will be set by caller
-		token,
-		result.IsValidToken(),
-		result.GetScore(),
-		result.ErrorCodes,
-		duration,
-	)
+	// Log validation; errors are logged with the request
+	if err == nil {
+		span.SetAttributes(
+			attribute.StringSlice("recaptcha.reasons", result.Reasons),
+			attribute.StringSlice("recaptcha.extended_verdict_reasons", result.ExtendedVerdictReasons),
+		)
+
+		s.telemetry.LogValidation(
+			"", // requestID will be set by caller
+			req.Token,
+			result.IsValidToken(),
+			result.GetScore(),
+			result.ErrorCodes,
+			riskReasons(result),
+			duration,
+		)
+	}
 
 	return result, err
 }
 
+// coalesce runs validate once for concurrent requests with the same cache
+// key, and gives every request its result. The shared validation is not
+// cancelled when the request that started it goes away. In distributed mode
+// it also runs under a cache lock, so one pod validates for all pods.
+func (s *Service) coalesce(ctx context.Context, cacheKey, provider string, policy config.Policy, labels metric.MeasurementOption, validate func(context.Context) (*recaptcha.ValidationResult, error)) (*recaptcha.ValidationResult, error) {
+	if s.config.CoalesceMode != "local" && s.config.CoalesceMode != "distributed" {
+		return validate(ctx)
+	}
+
+	shared := context.WithoutCancel(ctx)
+	call := s.inflight.DoChan(cacheKey, func() (interface{}, error) {
+		if s.config.CoalesceMode == "distributed" {
+			return s.validateLocked(shared, cacheKey, provider, policy, labels, validate)
+		}
+		return validate(shared)
+	})
+
+	select {
+	case result := <-call:
+		if result.Shared && s.metrics != nil {
+			s.metrics.CoalescedRequests.Add(ctx, 1, labels, metric.WithAttributes(attribute.String("scope", "local")))
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*recaptcha.ValidationResult), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// validateLocked runs validate under a cache lock on the cache key. While
+// another pod holds the lock, it waits for that pod's result in the cache,
+// and validates itself if the lock is released or expires without one. If
+// the lock cannot be taken it validates without it.
+func (s *Service) validateLocked(ctx context.Context, cacheKey, provider string, policy config.Policy, labels metric.MeasurementOption, validate func(context.Context) (*recaptcha.ValidationResult, error)) (*recaptcha.ValidationResult, error) {
+	// The lock outlives a validation that runs to its timeout
+	lockTTL := s.config.ProviderTimeout(provider) + time.Second
+	deadline := time.Now().Add(lockTTL)
+
+	for {
+		unlock, err := s.cache.Lock(ctx, cacheKey, lockTTL)
+		if err == nil {
+			defer unlock()
+			return validate(ctx)
+		}
+		if !errors.Is(err, cache.ErrLocked) {
+			s.logCacheError(err, "Failed to lock validation, validating without coalescing")
+			return validate(ctx)
+		}
+
+		time.Sleep(coalescePollInterval)
+
+		cachedResult, err := s.cache.Get(ctx, cacheKey)
+		if err == nil && cachedResult != nil {
+			if s.metrics != nil {
+				s.metrics.CoalescedRequests.Add(ctx, 1, labels, metric.WithAttributes(attribute.String("scope", "distributed")))
+			}
+			return s.enforcePolicy(s.convertCacheResult(cachedResult), policy), nil
+		}
+
+		if time.Now().After(deadline) {
+			return validate(ctx)
+		}
+	}
+}
+
 // cacheResult caches the validation result
 func (s *Service) cacheResult(ctx context.Context, key string, result *recaptcha.ValidationResult) {
 	// Convert to cache format
 	cacheResult := &cache.ValidationResult{
 		Success:     result.Success,
 		Score:       result.Score,
+		Scored:      result.Scored,
 		Action:      result.Action,
 		ChallengeTS: result.ChallengeTS,
 		Hostname:    result.Hostname,
 		ErrorCodes:  result.ErrorCodes,
+		Provider:    result.Provider,
+		Assessment:  result.Assessment,
 		Timestamp:   time.Now(),
+
+		Reasons:                result.Reasons,
+		ExtendedVerdictReasons: result.ExtendedVerdictReasons,
+		AccountLabels:          result.AccountLabels,
 	}
 
-	// Determine TTL based on result
+	// Determine TTL based on result. Valid results must not outlive the
+	// token's own age limit.
 	ttl := time.Duration(s.config.CacheTTLSeconds) * time.Second
 	if !result.IsValidToken() {
 		ttl = time.Duration(s.config.CacheFailedTTLSeconds) * time.Second
+	} else if expiresAt, ok := s.tokenExpiry(result); ok {
+		if remaining := time.Until(expiresAt); remaining < ttl {
+			ttl = remaining
+		}
+		if ttl <= 0 {
+			return
+		}
 	}
 
 	// Cache the result
 	if err := s.cache.Set(ctx, key, cacheResult, ttl); err != nil {
-		s.telemetry.Logger.WithError(err).Warn("Failed to cache validation result")
+		s.logCacheError(err, "Failed to cache validation result")
 	}
 }
 
-// createResponse creates an authorization response
-func (s *Service) createResponse(result *recaptcha.ValidationResult, cacheStatus string) *AuthorizationResponse {
+// logCacheError logs a failed cache call. While the cache is bypassed every
+// call fails, so those are only logged at debug level.
+func (s *Service) logCacheError(err error, msg string) {
+	if errors.Is(err, cache.ErrCacheUnavailable) {
+		s.telemetry.Logger.WithError(err).Debug(msg)
+		return
+	}
+	s.telemetry.Logger.WithError(err).Warn(msg)
+}
+
+// cacheToken returns the token identifying the request in the cache. For the
+// provider chain this is every chain member's token, in chain order.
+func (s *Service) cacheToken(req *AuthorizationRequest) string {
+	if len(s.config.ProviderChain) == 0 {
+		return req.Token
+	}
+
+	tokens := make([]string, 0, len(s.config.ProviderChain))
+	for _, provider := range s.config.ProviderChain {
+		tokens = append(tokens, req.Tokens[provider])
+	}
+	return strings.Join(tokens, ":")
+}
+
+// tokenExpiry returns when the result's token exceeds its maximum age. ok is
+// false when no limit applies or the creation time is unknown.
+func (s *Service) tokenExpiry(result *recaptcha.ValidationResult) (time.Time, bool) {
+	maxAge := s.config.MaxTokenAge(result.Action)
+	if maxAge <= 0 || result.ChallengeTS == "" {
+		return time.Time{}, false
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, result.ChallengeTS)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return createdAt.Add(maxAge), true
+}
+
+// enforcePolicy rejects stale tokens, and results with risk reasons or
+// account labels the policy denies
+func (s *Service) enforcePolicy(result *recaptcha.ValidationResult, policy config.Policy) *recaptcha.ValidationResult {
+	result = s.enforceTokenAge(result)
+	result = s.enforceDenyReasons(result, policy.DenyReasons)
+	return s.enforceAccountLabels(result, policy.DenyAccountLabels)
+}
+
+// enforceTokenAge rejects valid results whose token is older than the
+// maximum age for its action
+func (s *Service) enforceTokenAge(result *recaptcha.ValidationResult) *recaptcha.ValidationResult {
+	if !result.IsValidToken() {
+		return result
+	}
+
+	expiresAt, ok := s.tokenExpiry(result)
+	if !ok || time.Now().Before(expiresAt) {
+		return result
+	}
+
+	stale := *result
+	stale.Success = false
+	stale.ErrorCodes = []string{"token-too-old"}
+	return &stale
+}
+
+// checkTokenReuse records a use of the token and returns a denial once it
+// has been used more than SingleUseMaxUses times or outside SingleUseWindow
+// of its first use. The first use is always allowed. A use that cannot be
+// recorded cannot be told from a replay, so the failure mode decides.
+func (s *Service) checkTokenReuse(ctx context.Context, token, failureMode string) *AuthorizationResponse {
+	// Track uses for as long as the token can be accepted, by Google or from
+	// the cache, so a replay cannot outlive its counter
+	ttl := tokenLifetime + time.Duration(s.config.CacheTTLSeconds)*time.Second
+	if s.config.SingleUseWindow > ttl {
+		ttl = s.config.SingleUseWindow
+	}
+
+	uses, firstUse, err := s.cache.Consume(ctx, cache.GenerateCacheKey(token, "consumed"), ttl)
+	if err != nil {
+		s.logCacheError(err, "Failed to record token use")
+		if failureMode == "fail_open" {
+			return nil
+		}
+		return &AuthorizationResponse{
+			Allowed: false,
+			Status:  "cache_unavailable",
+			Cache:   "miss",
+		}
+	}
+
+	if uses == 1 || (uses <= int64(s.config.SingleUseMaxUses) && time.Since(firstUse) <= s.config.SingleUseWindow) {
+		return nil
+	}
+
+	return &AuthorizationResponse{
+		Allowed: false,
+		Status:  "dupe",
+		Cache:   "miss",
+	}
+}
+
+// enforceDenyReasons rejects valid results carrying a risk analysis reason
+// the policy denies, regardless of score
+func (s *Service) enforceDenyReasons(result *recaptcha.ValidationResult, denyReasons []string) *recaptcha.ValidationResult {
+	if !result.IsValidToken() {
+		return result
+	}
+
+	for _, reason := range riskReasons(result) {
+		if slices.Contains(denyReasons, reason) {
+			denied := *result
+			denied.Success = false
+			denied.ErrorCodes = []string{"reason-denied"}
+			return &denied
+		}
+	}
+
+	return result
+}
+
+// enforceAccountLabels rejects valid results carrying an Account Defender
+// label the policy denies, regardless of score
+func (s *Service) enforceAccountLabels(result *recaptcha.ValidationResult, denyLabels []string) *recaptcha.ValidationResult {
+	if !result.IsValidToken() {
+		return result
+	}
+
+	for _, label := range result.AccountLabels {
+		if slices.Contains(denyLabels, label) {
+			denied := *result
+			denied.Success = false
+			denied.ErrorCodes = []string{"account-label-denied"}
+			return &denied
+		}
+	}
+
+	return result
+}
+
+// hashAccountID returns the HMAC-SHA256 of an account identifier, or nil
+// when Account Defender is disabled or no identifier was sent
+func (s *Service) hashAccountID(accountID string) []byte {
+	if accountID == "" || s.config.AccountIDHMACKey == "" {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.config.AccountIDHMACKey))
+	mac.Write([]byte(accountID))
+	return mac.Sum(nil)
+}
+
+// riskReasons returns the result's reasons followed by its extended verdict
+// reasons
+func riskReasons(result *recaptcha.ValidationResult) []string {
+	if len(result.ExtendedVerdictReasons) == 0 {
+		return result.Reasons
+	}
+	return append(slices.Clip(result.Reasons), result.ExtendedVerdictReasons...)
+}
+
+// createResponse creates an authorization response, deciding by the score
+// bands when the policy has them
+func (s *Service) createResponse(result *recaptcha.ValidationResult, cacheStatus string, bands *config.ScoreBands) *AuthorizationResponse {
 	response := &AuthorizationResponse{
-		Allowed: result.IsValidToken(),
-		Status:  "valid",
-		Cache:   cacheStatus,
+		Allowed:  result.IsValidToken(),
+		Status:   "valid",
+		Cache:    cacheStatus,
+		Provider: result.Provider,
+
+		Assessment: result.Assessment,
+		Reasons:    riskReasons(result),
+
+		AccountLabels: result.AccountLabels,
 	}
 
 	if !result.IsValidToken() {
@@ -272,16 +947,109 @@ func (s *Service) createResponse(result *recaptcha.ValidationResult, cacheStatus
 		}
 	}
 
-	if result.Score > 0 {
+	if result.Scored {
 		response.Score = strconv.FormatFloat(result.Score, 'f', 2, 64)
 	}
 
+	if bands != nil {
+		applyScoreBands(response, result, bands)
+	}
+
 	return response
 }
 
+// recordScore records the score of a result in the score histogram and the
+// in-process score window. Results without a score are skipped. The action
+// comes from the token, so actions outside the expected ones are recorded as
+// "other" to bound the label set.
+func (s *Service) recordScore(ctx context.Context, result *recaptcha.ValidationResult, actions []string, siteKeyName, cacheStatus string) {
+	if !result.Scored {
+		return
+	}
+
+	action := result.Action
+	if !slices.Contains(actions, action) {
+		action = "other"
+	}
+
+	s.scores.Record(result.Score, action, siteKeyName)
+
+	if s.metrics != nil {
+		s.metrics.Scores.Record(ctx, result.Score, metric.WithAttributes(
+			attribute.String("action", action),
+			attribute.String("site_key", siteKeyName),
+			attribute.String("cache", cacheStatus),
+		))
+	}
+}
+
+// applyShadow reports the decision of a policy in shadow mode or with a
+// shadow threshold, and allows the request when the policy is in shadow mode.
+// With a shadow threshold the reported decision is the one that threshold
+// would make. result is nil when no validation result was available.
+func (s *Service) applyShadow(ctx context.Context, response *AuthorizationResponse, result *recaptcha.ValidationResult, policyName string, policy config.Policy, labels metric.MeasurementOption) {
+	if !policy.Shadow && policy.ShadowThreshold == nil {
+		return
+	}
+
+	wouldAllow := response.Allowed
+	if policy.ShadowThreshold != nil && result != nil {
+		wouldAllow = allowedAt(result, *policy.ShadowThreshold)
+	}
+
+	response.ShadowDecision = "allow"
+	if !wouldAllow {
+		response.ShadowDecision = "deny"
+	}
+
+	if s.metrics != nil {
+		s.metrics.ShadowDecisions.Add(ctx, 1, labels, metric.WithAttributes(
+			attribute.String("policy", policyName),
+			attribute.Bool("would_deny", !wouldAllow),
+		))
+	}
+
+	if policy.Shadow && !response.Allowed {
+		response.Allowed = true
+		response.StatusCode = 0
+	}
+}
+
+// allowedAt reports whether the result would be allowed at threshold. Only a
+// failed score check is re-evaluated; any other failure denies.
+func allowedAt(result *recaptcha.ValidationResult, threshold float64) bool {
+	switch {
+	case result.IsValidToken():
+		return !result.Scored || result.Score >= threshold
+	case len(result.ErrorCodes) == 1 && result.ErrorCodes[0] == "score-below-threshold":
+		return result.Score >= threshold
+	default:
+		return false
+	}
+}
+
+// applyScoreBands sets the response's risk level and HTTP status from the
+// bands. Invalid results are high risk; valid results without a score
+// (Turnstile, reCAPTCHA v2) are low risk.
+func applyScoreBands(response *AuthorizationResponse, result *recaptcha.ValidationResult, bands *config.ScoreBands) {
+	switch {
+	case !result.IsValidToken():
+		response.RiskLevel = RiskLevelHigh
+		response.StatusCode = bands.DenyStatus
+	case !result.Scored || result.Score >= bands.Allow:
+		response.RiskLevel = RiskLevelLow
+		response.StatusCode = bands.AllowStatus
+	default:
+		response.Allowed = bands.ChallengeStatus < 300
+		response.Status = "challenge"
+		response.RiskLevel = RiskLevelMedium
+		response.StatusCode = bands.ChallengeStatus
+	}
+}
+
 // handleCircuitBreakerOpen handles requests when circuit breaker is open
-func (s *Service) handleCircuitBreakerOpen() *AuthorizationResponse {
-	if s.config.FailureMode == "fail_open" {
+func (s *Service) handleCircuitBreakerOpen(failureMode string) *AuthorizationResponse {
+	if failureMode == "fail_open" {
 		return &AuthorizationResponse{
 			Allowed: true,
 			Status:  "degraded",
@@ -297,8 +1065,8 @@ func (s *Service) handleCircuitBreakerOpen() *AuthorizationResponse {
 }
 
 // handleValidationError handles validation errors
-func (s *Service) handleValidationError(err error) *AuthorizationResponse {
-	if s.config.FailureMode == "fail_open" {
+func (s *Service) handleValidationError(err error, failureMode string) *AuthorizationResponse {
+	if failureMode == "fail_open" {
 		return &AuthorizationResponse{
 			Allowed: true,
 			Status:  "degraded",
@@ -314,14 +1082,20 @@ func (s *Service) handleValidationError(err error) *AuthorizationResponse {
 }
 
 // logRequest logs the request with telemetry
-func (s *Service) logRequest(requestID, token, status string, cacheHit bool, responseTime time.Duration, err error) {
+func (s *Service) logRequest(requestID, token, provider, siteKey, policy string, response *AuthorizationResponse, cacheHit bool, responseTime time.Duration, err error) {
 	s.telemetry.LogRequest(observability.LogFields{
-		RequestID:     requestID,
-		Token:         token,
-		ValidationResult: status,
-		CacheHit:      cacheHit,
-		ResponseTime:  responseTime,
-		Error:         err,
+		RequestID:           requestID,
+		Token:               token,
+		Provider:            provider,
+		SiteKey:             siteKey,
+		Policy:              policy,
+		ValidationResult:    response.Status,
+		Reasons:             response.Reasons,
+		AccountLabels:       response.AccountLabels,
+		ShadowDecision:      response.ShadowDecision,
+		CacheHit:            cacheHit,
+		ResponseTime:        responseTime,
+		Error:               err,
 		CircuitBreakerState: s.circuitBreaker.GetStateString(),
 	})
 }
@@ -331,42 +1105,88 @@ func (s *Service) GetHealth() map[string]interface{} {
 	stats := s.circuitBreaker.GetStats()
 	cacheStats := s.cache.GetStats()
 
+	// Without the cache every request is validated, which still works
+	status := "healthy"
+	if cacheStats.Degraded {
+		status = "degraded"
+	}
+
 	return map[string]interface{}{
-		"status": "healthy",
+		"status":    status,
 		"timestamp": time.Now().Format(time.RFC3339),
 		"circuit_breaker": map[string]interface{}{
-			"state":           stats.State,
-			"failure_count":   stats.FailureCount,
-			"total_requests":  stats.TotalRequests,
-			"total_failures":  stats.TotalFailures,
+			"state":          stats.State,
+			"failure_count":  stats.FailureCount,
+			"total_requests": stats.TotalRequests,
+			"total_failures": stats.TotalFailures,
 		},
+		"provider_breakers": s.chainBreakerStats(),
 		"cache": map[string]interface{}{
-			"hits":   cacheStats.Hits,
-			"misses": cacheStats.Misses,
-			"size":   cacheStats.Size,
+			"hits":     cacheStats.Hits,
+			"misses":   cacheStats.Misses,
+			"size":     cacheStats.Size,
+			"degraded": cacheStats.Degraded,
 		},
 		"config": map[string]interface{}{
+			"recaptcha_backend":    s.config.RecaptchaBackend,
 			"recaptcha_project_id": s.config.RecaptchaProjectID,
 			"recaptcha_action":     s.config.RecaptchaAction,
 			"failure_mode":         s.config.FailureMode,
+			"cache_type":           s.config.CacheType,
 			"mock_mode":            s.config.MockMode,
+			"providers":            s.config.Providers(),
+			"policies":             s.policyNames(),
+			"site_keys":            s.siteKeyNames(),
 		},
 	}
 }
 
+// chainBreakerStats returns the circuit breaker stats of each chain provider
+func (s *Service) chainBreakerStats() map[string]circuitbreaker.Stats {
+	stats := make(map[string]circuitbreaker.Stats, len(s.chainBreakers))
+	for provider, breaker := range s.chainBreakers {
+		stats[provider] = breaker.GetStats()
+	}
+	return stats
+}
+
+// policyNames returns the names of the configured policies
+func (s *Service) policyNames() []string {
+	names := make([]string, 0, len(s.config.Policies))
+	for name := range s.config.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// siteKeyNames returns the names of the configured site keys
+func (s *Service) siteKeyNames() []string {
+	names := make([]string, 0, len(s.config.SiteKeys))
+	for name := range s.config.SiteKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // GetMetrics returns the current metrics
 func (s *Service) GetMetrics() map[string]interface{} {
 	stats := s.circuitBreaker.GetStats()
 	cacheStats := s.cache.GetStats()
 
 	return map[string]interface{}{
-		"circuit_breaker": stats,
-		"cache":          cacheStats,
+		"circuit_breaker":    stats,
+		"cache":              cacheStats,
+		"score_distribution": s.scores.Distribution(),
 	}
 }
 
 // Shutdown gracefully shuts down the service
 func (s *Service) Shutdown(ctx context.Context) error {
+	if err := s.cache.Close(); err != nil {
+		s.telemetry.Logger.WithError(err).Warn("Failed to close cache")
+	}
 	return s.telemetry.Shutdown(ctx)
 }
 
@@ -380,9 +1200,16 @@ func (s *Service) convertCacheResult(cachedResult *cache.ValidationResult) *reca
 	return &recaptcha.ValidationResult{
 		Success:     cachedResult.Success,
 		Score:       cachedResult.Score,
+		Scored:      cachedResult.Scored,
 		Action:      cachedResult.Action,
 		ChallengeTS: cachedResult.ChallengeTS,
 		Hostname:    cachedResult.Hostname,
 		ErrorCodes:  cachedResult.ErrorCodes,
+		Provider:    cachedResult.Provider,
+		Assessment:  cachedResult.Assessment,
+
+		Reasons:                cachedResult.Reasons,
+		ExtendedVerdictReasons: cachedResult.ExtendedVerdictReasons,
+		AccountLabels:          cachedResult.AccountLabels,
 	}
 } 
\ No newline at end of file
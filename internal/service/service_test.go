@@ -0,0 +1,888 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prefeitura-rio/app-ext-authz/internal/cache"
+	"github.com/prefeitura-rio/app-ext-authz/internal/config"
+	"github.com/prefeitura-rio/app-ext-authz/internal/recaptcha"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// stubClient is a provider that returns the same result for every token,
+// judging its score against the request's threshold as the real clients do
+type stubClient struct {
+	mu       sync.Mutex
+	result   recaptcha.ValidationResult
+	err      error
+	delay    time.Duration
+	requests []*recaptcha.ValidationRequest
+}
+
+func (c *stubClient) Validate(ctx context.Context, req *recaptcha.ValidationRequest) (*recaptcha.ValidationResult, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	result := c.result
+	if result.Action == "" && len(req.Actions) > 0 {
+		result.Action = req.Actions[0]
+	}
+	if result.Success && result.Scored && req.Threshold != nil && result.Score < *req.Threshold {
+		result.Success = false
+		result.ErrorCodes = []string{"score-below-threshold"}
+	}
+	return &result, nil
+}
+
+// calls returns the number of validations the stub served
+func (c *stubClient) calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.requests)
+}
+
+// lastRequest returns the last validation request the stub served
+func (c *stubClient) lastRequest() *recaptcha.ValidationRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.requests) == 0 {
+		return nil
+	}
+	return c.requests[len(c.requests)-1]
+}
+
+func scored(score float64) *stubClient {
+	return &stubClient{result: recaptcha.ValidationResult{Success: true, Score: score, Scored: true}}
+}
+
+// newTestService creates a service with the memory cache whose reCAPTCHA
+// provider is client. configure adjusts the configuration first.
+func newTestService(t *testing.T, client recaptcha.Client, configure func(cfg *config.Config)) *Service {
+	t.Helper()
+
+	cfg := &config.Config{
+		RecaptchaProjectID:             "test-project",
+		RecaptchaSiteKey:               "test_site_key",
+		RecaptchaAction:                "authz",
+		RecaptchaV3Threshold:           0.5,
+		GoogleAPITimeoutSeconds:        5,
+		CacheType:                      "memory",
+		CacheTTLSeconds:                30,
+		CacheFailedTTLSeconds:          30,
+		CacheLocalMaxItems:             1000,
+		FailureMode:                    "fail_closed",
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 5,
+		CircuitBreakerRecoveryTime:     time.Minute,
+		ScoreWindow:                    time.Minute,
+		LogLevel:                       "error",
+		MockMode:                       true,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	svc, err := NewService(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	svc.clients[recaptcha.ProviderRecaptcha] = client
+	t.Cleanup(func() { svc.Shutdown(context.Background()) })
+
+	return svc
+}
+
+func authorize(t *testing.T, svc *Service, req *AuthorizationRequest) *AuthorizationResponse {
+	t.Helper()
+
+	response, err := svc.Authorize(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return response
+}
+
+func TestService_Authorize_Policies(t *testing.T) {
+	client := scored(0.6)
+	svc := newTestService(t, client, func(cfg *config.Config) {
+		cfg.Policies = map[string]config.Policy{
+			"login":  {Actions: []string{"login"}, Threshold: 0.8, FailureMode: "fail_closed"},
+			"search": {Actions: []string{"search", "browse"}, Threshold: 0.2, FailureMode: "fail_closed"},
+		}
+	})
+
+	tests := []struct {
+		name              string
+		policy            string
+		strict            bool
+		expectedAllow     bool
+		expectedStatus    string
+		expectedActions   []string
+		expectedThreshold float64
+		expectedErr       error
+	}{
+		{name: "default policy", expectedAllow: true, expectedStatus: "valid", expectedActions: []string{"authz"}, expectedThreshold: 0.5},
+		{name: "stricter policy", policy: "login", expectedAllow: false, expectedStatus: "score-below-threshold", expectedActions: []string{"login"}, expectedThreshold: 0.8},
+		{name: "looser policy", policy: "search", expectedAllow: true, expectedStatus: "valid", expectedActions: []string{"search", "browse"}, expectedThreshold: 0.2},
+		{name: "unknown policy uses default", policy: "checkout", expectedAllow: true, expectedStatus: "valid", expectedActions: []string{"authz"}, expectedThreshold: 0.5},
+		{name: "unknown policy rejected", policy: "checkout", strict: true, expectedErr: ErrUnknownSelector},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := client.calls()
+			response, err := svc.Authorize(context.Background(), &AuthorizationRequest{
+				Token:           "token-" + tt.name,
+				Policy:          tt.policy,
+				StrictSelectors: tt.strict,
+			})
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+				}
+				if client.calls() != calls {
+					t.Errorf("Expected no provider call")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+
+			req := client.lastRequest()
+			if !slices.Equal(req.Actions, tt.expectedActions) {
+				t.Errorf("Expected actions %v, got %v", tt.expectedActions, req.Actions)
+			}
+			if req.Threshold == nil || *req.Threshold != tt.expectedThreshold {
+				t.Errorf("Expected threshold %v, got %v", tt.expectedThreshold, req.Threshold)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_PolicyFailureMode(t *testing.T) {
+	svc := newTestService(t, &stubClient{err: errUnavailable}, func(cfg *config.Config) {
+		cfg.Policies = map[string]config.Policy{
+			"public": {Actions: []string{"authz"}, Threshold: 0.5, FailureMode: "fail_open"},
+		}
+	})
+
+	tests := []struct {
+		name           string
+		policy         string
+		expectedAllow  bool
+		expectedStatus string
+	}{
+		{name: "fail closed", expectedAllow: false, expectedStatus: "timeout"},
+		{name: "fail open", policy: "public", expectedAllow: true, expectedStatus: "degraded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token-" + tt.name, Policy: tt.policy})
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_SiteKeys(t *testing.T) {
+	client := scored(0.6)
+	svc := newTestService(t, client, func(cfg *config.Config) {
+		cfg.SiteKeys = map[string]config.SiteKey{
+			"android": {Key: "android_site_key", Platform: "android", Actions: []string{"login"}, Threshold: 0.7},
+			"ios":     {Key: "ios_site_key", Platform: "ios", Actions: []string{"login"}, Threshold: 0.3},
+		}
+	})
+
+	tests := []struct {
+		name           string
+		siteKey        string
+		strict         bool
+		expectedAllow  bool
+		expectedStatus string
+		expectedKey    string
+		expectedErr    error
+	}{
+		{name: "default key", expectedAllow: true, expectedStatus: "valid", expectedKey: "test_site_key"},
+		{name: "by name", siteKey: "ios", expectedAllow: true, expectedStatus: "valid", expectedKey: "ios_site_key"},
+		{name: "by key value", siteKey: "android_site_key", expectedAllow: false, expectedStatus: "score-below-threshold", expectedKey: "android_site_key"},
+		{name: "unknown key uses default", siteKey: "windows", expectedAllow: true, expectedStatus: "valid", expectedKey: "test_site_key"},
+		{name: "unknown key rejected", siteKey: "windows", strict: true, expectedErr: ErrUnknownSelector},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := client.calls()
+			response, err := svc.Authorize(context.Background(), &AuthorizationRequest{
+				Token:           "token-" + tt.name,
+				SiteKey:         tt.siteKey,
+				StrictSelectors: tt.strict,
+			})
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+				}
+				if client.calls() != calls {
+					t.Errorf("Expected no provider call")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+			if key := client.lastRequest().SiteKey; key != tt.expectedKey {
+				t.Errorf("Expected site key %q, got %q", tt.expectedKey, key)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_SiteKeysScopeCache(t *testing.T) {
+	client := scored(0.9)
+	svc := newTestService(t, client, func(cfg *config.Config) {
+		cfg.SiteKeys = map[string]config.SiteKey{
+			"android": {Key: "android_site_key", Platform: "android", Actions: []string{"authz"}, Threshold: 0.5},
+		}
+	})
+
+	for _, siteKey := range []string{"", "android", "", "android"} {
+		authorize(t, svc, &AuthorizationRequest{Token: "token", SiteKey: siteKey})
+	}
+
+	// A result judged for one key is not served for another
+	if calls := client.calls(); calls != 2 {
+		t.Errorf("Expected 2 provider calls, got %d", calls)
+	}
+}
+
+func TestService_Authorize_TokenAge(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxAge         time.Duration
+		maxAgeByAction map[string]time.Duration
+		expectedAllow  bool
+		expectedStatus string
+	}{
+		{name: "no limit", expectedAllow: true, expectedStatus: "valid"},
+		{name: "within limit", maxAge: 2 * time.Minute, expectedAllow: true, expectedStatus: "valid"},
+		{name: "too old", maxAge: 30 * time.Second, expectedAllow: false, expectedStatus: "token-too-old"},
+		{name: "action limit", maxAgeByAction: map[string]time.Duration{"authz": 30 * time.Second}, expectedAllow: false, expectedStatus: "token-too-old"},
+		{name: "action limit overrides global", maxAge: 30 * time.Second, maxAgeByAction: map[string]time.Duration{"authz": 2 * time.Minute}, expectedAllow: true, expectedStatus: "valid"},
+		{name: "other action's limit", maxAgeByAction: map[string]time.Duration{"login": 30 * time.Second}, expectedAllow: true, expectedStatus: "valid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The token was minted a minute ago
+			client := scored(0.9)
+			client.result.ChallengeTS = time.Now().Add(-time.Minute).Format(time.RFC3339)
+
+			svc := newTestService(t, client, func(cfg *config.Config) {
+				cfg.TokenMaxAge = tt.maxAge
+				cfg.TokenMaxAgeByAction = tt.maxAgeByAction
+			})
+
+			// The second request is answered from the cache, which must
+			// enforce the limit too
+			for i := 0; i < 2; i++ {
+				response := authorize(t, svc, &AuthorizationRequest{Token: "token"})
+
+				if response.Allowed != tt.expectedAllow {
+					t.Errorf("Request %d: expected allowed=%v, got %v", i+1, tt.expectedAllow, response.Allowed)
+				}
+				if response.Status != tt.expectedStatus {
+					t.Errorf("Request %d: expected status=%q, got %q", i+1, tt.expectedStatus, response.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Authorize_SingleUseTokens(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxUses         int
+		window          time.Duration
+		expectedAllowed []bool
+	}{
+		{name: "single use", maxUses: 1, expectedAllowed: []bool{true, false, false}},
+		{name: "two uses in window", maxUses: 2, window: time.Minute, expectedAllowed: []bool{true, true, false}},
+		{name: "two uses outside window", maxUses: 2, window: -time.Second, expectedAllowed: []bool{true, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := scored(0.9)
+			svc := newTestService(t, client, func(cfg *config.Config) {
+				cfg.SingleUseTokens = true
+				cfg.SingleUseMaxUses = tt.maxUses
+				cfg.SingleUseWindow = tt.window
+			})
+
+			for i, expected := range tt.expectedAllowed {
+				response := authorize(t, svc, &AuthorizationRequest{Token: "token"})
+				if response.Allowed != expected {
+					t.Errorf("Presentation %d: expected allowed=%v, got %v (%s)", i+1, expected, response.Allowed, response.Status)
+				}
+				if !expected && response.Status != "dupe" {
+					t.Errorf("Presentation %d: expected status dupe, got %q", i+1, response.Status)
+				}
+			}
+
+			// Replays are rejected before the cache or the provider
+			if calls := client.calls(); calls != 1 {
+				t.Errorf("Expected 1 provider call, got %d", calls)
+			}
+		})
+	}
+}
+
+// unrecordedCache is a cache that fails to record token uses
+type unrecordedCache struct {
+	cache.Cache
+}
+
+func (c unrecordedCache) Consume(ctx context.Context, key string, ttl time.Duration) (int64, time.Time, error) {
+	return 0, time.Time{}, cache.ErrCacheUnavailable
+}
+
+func TestService_Authorize_SingleUseTokensUnrecorded(t *testing.T) {
+	tests := []struct {
+		failureMode     string
+		expectedAllowed bool
+		expectedStatus  string
+	}{
+		{failureMode: "fail_open", expectedAllowed: true, expectedStatus: "valid"},
+		{failureMode: "fail_closed", expectedAllowed: false, expectedStatus: "cache_unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.failureMode, func(t *testing.T) {
+			client := scored(0.9)
+			svc := newTestService(t, client, func(cfg *config.Config) {
+				cfg.SingleUseTokens = true
+				cfg.SingleUseMaxUses = 1
+				cfg.FailureMode = tt.failureMode
+			})
+			svc.cache = unrecordedCache{svc.cache}
+
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token"})
+			if response.Allowed != tt.expectedAllowed || response.Status != tt.expectedStatus {
+				t.Errorf("Expected allowed=%v status=%q, got allowed=%v status=%q", tt.expectedAllowed, tt.expectedStatus, response.Allowed, response.Status)
+			}
+		})
+	}
+}
+
+// authorizeConcurrently sends the same request to the services n times at
+// once, spreading the requests across them, and returns the responses
+func authorizeConcurrently(t *testing.T, services []*Service, n int, req *AuthorizationRequest) []*AuthorizationResponse {
+	t.Helper()
+
+	responses := make([]*AuthorizationResponse, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := services[i%len(services)].Authorize(context.Background(), req)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			responses[i] = response
+		}(i)
+	}
+	wg.Wait()
+
+	return responses
+}
+
+func TestService_Authorize_Coalescing(t *testing.T) {
+	const requests = 10
+
+	tests := []struct {
+		name          string
+		mode          string
+		pods          int
+		expectedCalls int
+	}{
+		{name: "off", mode: "off", pods: 1, expectedCalls: requests},
+		{name: "local", mode: "local", pods: 1, expectedCalls: 1},
+		{name: "distributed", mode: "distributed", pods: 2, expectedCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := scored(0.9)
+			client.delay = 200 * time.Millisecond
+
+			// Pods share the first pod's cache, standing in for Redis
+			services := make([]*Service, tt.pods)
+			for i := range services {
+				services[i] = newTestService(t, client, func(cfg *config.Config) {
+					cfg.CoalesceMode = tt.mode
+				})
+				services[i].cache = services[0].cache
+			}
+
+			for i, response := range authorizeConcurrently(t, services, requests, &AuthorizationRequest{Token: "token"}) {
+				if response != nil && !response.Allowed {
+					t.Errorf("Request %d: expected allowed, got status %q", i, response.Status)
+				}
+			}
+			if calls := client.calls(); calls != tt.expectedCalls {
+				t.Errorf("Expected %d provider calls, got %d", tt.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_CircuitBreakerRecovers(t *testing.T) {
+	client := &stubClient{err: errUnavailable}
+	svc := newTestService(t, client, func(cfg *config.Config) {
+		cfg.CircuitBreakerFailureThreshold = 2
+		cfg.CircuitBreakerRecoveryTime = 50 * time.Millisecond
+	})
+
+	for i := 0; i < 2; i++ {
+		response := authorize(t, svc, &AuthorizationRequest{Token: fmt.Sprintf("failing-%d", i)})
+		if response.Status != "timeout" {
+			t.Fatalf("Expected status timeout, got %q", response.Status)
+		}
+	}
+
+	response := authorize(t, svc, &AuthorizationRequest{Token: "rejected"})
+	if response.Allowed || response.Status != "circuit_breaker_open" {
+		t.Fatalf("Expected a circuit_breaker_open denial, got allowed=%v status=%q", response.Allowed, response.Status)
+	}
+	if calls := client.calls(); calls != 2 {
+		t.Errorf("Expected the open breaker to skip the provider, got %d calls", calls)
+	}
+	if err := svc.callRecaptcha(context.Background(), func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen from callRecaptcha, got %v", err)
+	}
+
+	// After the recovery time the next call is let through as a probe
+	time.Sleep(60 * time.Millisecond)
+	client.mu.Lock()
+	client.err = nil
+	client.result = recaptcha.ValidationResult{Success: true, Score: 0.9, Scored: true}
+	client.mu.Unlock()
+
+	response = authorize(t, svc, &AuthorizationRequest{Token: "probe"})
+	if !response.Allowed {
+		t.Errorf("Expected the probe to be allowed, got status %q", response.Status)
+	}
+	if calls := client.calls(); calls != 3 {
+		t.Errorf("Expected the probe to reach the provider, got %d calls", calls)
+	}
+	if !svc.circuitBreaker.IsClosed() {
+		t.Errorf("Expected the breaker to close, got %s", svc.circuitBreaker.GetStateString())
+	}
+}
+
+// annotatingClient is a stub provider that also records annotations
+type annotatingClient struct {
+	*stubClient
+	annotations []*recaptcha.AnnotationRequest
+	annotateErr error
+}
+
+func (c *annotatingClient) Annotate(ctx context.Context, req *recaptcha.AnnotationRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.annotations = append(c.annotations, req)
+	return c.annotateErr
+}
+
+func TestService_Annotate(t *testing.T) {
+	assessment := "projects/test-project/assessments/abc"
+
+	tests := []struct {
+		name                string
+		req                 *AnnotationRequest
+		annotateErr         error
+		expectedErr         error
+		expectedAnnotations int
+	}{
+		{name: "legitimate", req: &AnnotationRequest{Assessment: assessment, Annotation: "LEGITIMATE"}, expectedAnnotations: 1},
+		{name: "fraudulent with reasons", req: &AnnotationRequest{Assessment: assessment, Annotation: "FRAUDULENT", Reasons: []string{"CHARGEBACK"}}, expectedAnnotations: 1},
+		{name: "missing assessment", req: &AnnotationRequest{Annotation: "LEGITIMATE"}, expectedErr: recaptcha.ErrInvalidAnnotation},
+		{name: "unknown annotation", req: &AnnotationRequest{Assessment: assessment, Annotation: "SUSPICIOUS"}, expectedErr: recaptcha.ErrInvalidAnnotation},
+		{name: "unknown reason", req: &AnnotationRequest{Assessment: assessment, Annotation: "FRAUDULENT", Reasons: []string{"SPAM"}}, expectedErr: recaptcha.ErrInvalidAnnotation},
+		{name: "provider error", req: &AnnotationRequest{Assessment: assessment, Annotation: "LEGITIMATE"}, annotateErr: errUnavailable, expectedErr: errUnavailable, expectedAnnotations: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &annotatingClient{stubClient: scored(0.9), annotateErr: tt.annotateErr}
+			svc := newTestService(t, client, nil)
+
+			err := svc.Annotate(context.Background(), tt.req)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+
+			if len(client.annotations) != tt.expectedAnnotations {
+				t.Fatalf("Expected %d annotations, got %d", tt.expectedAnnotations, len(client.annotations))
+			}
+			if tt.expectedAnnotations > 0 {
+				annotation := client.annotations[0]
+				if annotation.Assessment != tt.req.Assessment || annotation.Annotation != tt.req.Annotation || !slices.Equal(annotation.Reasons, tt.req.Reasons) {
+					t.Errorf("Expected annotation %+v, got %+v", tt.req, annotation)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Annotate_Unsupported(t *testing.T) {
+	svc := newTestService(t, scored(0.9), nil)
+
+	err := svc.Annotate(context.Background(), &AnnotationRequest{Assessment: "projects/test-project/assessments/abc", Annotation: "LEGITIMATE"})
+	if !errors.Is(err, ErrAnnotationUnsupported) {
+		t.Errorf("Expected ErrAnnotationUnsupported, got %v", err)
+	}
+}
+
+func TestService_Authorize_DenyReasons(t *testing.T) {
+	tests := []struct {
+		name           string
+		score          float64
+		denyReasons    []string
+		expectedAllow  bool
+		expectedStatus string
+	}{
+		{name: "no denied reasons", score: 0.9, expectedAllow: true, expectedStatus: "valid"},
+		{name: "other reason denied", score: 0.9, denyReasons: []string{"TOO_MUCH_TRAFFIC"}, expectedAllow: true, expectedStatus: "valid"},
+		{name: "reason denied", score: 0.9, denyReasons: []string{"AUTOMATION"}, expectedAllow: false, expectedStatus: "reason-denied"},
+		{name: "extended verdict denied", score: 0.9, denyReasons: []string{"HIGH_RISK_IP"}, expectedAllow: false, expectedStatus: "reason-denied"},
+		{name: "low score keeps its status", score: 0.1, denyReasons: []string{"AUTOMATION"}, expectedAllow: false, expectedStatus: "score-below-threshold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := scored(tt.score)
+			client.result.Reasons = []string{"AUTOMATION"}
+			client.result.ExtendedVerdictReasons = []string{"HIGH_RISK_IP"}
+
+			svc := newTestService(t, client, func(cfg *config.Config) {
+				cfg.DenyReasons = tt.denyReasons
+			})
+
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token"})
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+			if expected := []string{"AUTOMATION", "HIGH_RISK_IP"}; !slices.Equal(response.Reasons, expected) {
+				t.Errorf("Expected reasons %v, got %v", expected, response.Reasons)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_AccountDefender(t *testing.T) {
+	const hmacKey = "account-key"
+	mac := hmac.New(sha256.New, []byte(hmacKey))
+	mac.Write([]byte("user-1"))
+	expectedHash := mac.Sum(nil)
+
+	tests := []struct {
+		name           string
+		hmacKey        string
+		accountID      string
+		denyLabels     []string
+		expectedHash   []byte
+		expectedAllow  bool
+		expectedStatus string
+	}{
+		{name: "hashed account", hmacKey: hmacKey, accountID: "user-1", expectedHash: expectedHash, expectedAllow: true, expectedStatus: "valid"},
+		{name: "no account", hmacKey: hmacKey, expectedAllow: true, expectedStatus: "valid"},
+		{name: "disabled", accountID: "user-1", expectedAllow: true, expectedStatus: "valid"},
+		{name: "other label denied", hmacKey: hmacKey, accountID: "user-1", denyLabels: []string{"SUSPICIOUS_ACCOUNT_CREATION"}, expectedHash: expectedHash, expectedAllow: true, expectedStatus: "valid"},
+		{name: "label denied", hmacKey: hmacKey, accountID: "user-1", denyLabels: []string{"SUSPICIOUS_LOGIN_ACTIVITY"}, expectedHash: expectedHash, expectedAllow: false, expectedStatus: "account-label-denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := scored(0.9)
+			client.result.AccountLabels = []string{"SUSPICIOUS_LOGIN_ACTIVITY"}
+
+			svc := newTestService(t, client, func(cfg *config.Config) {
+				cfg.AccountIDHMACKey = tt.hmacKey
+				cfg.DenyAccountLabels = tt.denyLabels
+			})
+
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token", AccountID: tt.accountID})
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+			if hash := client.lastRequest().HashedAccountID; !bytes.Equal(hash, tt.expectedHash) {
+				t.Errorf("Expected hashed account ID %x, got %x", tt.expectedHash, hash)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_AccountScopesCache(t *testing.T) {
+	client := scored(0.9)
+	svc := newTestService(t, client, func(cfg *config.Config) {
+		cfg.AccountIDHMACKey = "account-key"
+	})
+
+	for _, accountID := range []string{"user-1", "user-2", "user-1"} {
+		authorize(t, svc, &AuthorizationRequest{Token: "token", AccountID: accountID})
+	}
+
+	// Account labels belong to the account, so results are not shared
+	if calls := client.calls(); calls != 2 {
+		t.Errorf("Expected 2 provider calls, got %d", calls)
+	}
+}
+
+func TestService_Authorize_Shadow(t *testing.T) {
+	bands := &config.ScoreBands{Allow: 0.7, Challenge: 0.3, AllowStatus: 200, ChallengeStatus: 401, DenyStatus: 403}
+
+	tests := []struct {
+		name           string
+		client         *stubClient
+		bands          *config.ScoreBands
+		expectedStatus string
+		expectedShadow string
+	}{
+		{name: "would allow", client: scored(0.9), expectedStatus: "valid", expectedShadow: "allow"},
+		{name: "would deny", client: scored(0.2), expectedStatus: "score-below-threshold", expectedShadow: "deny"},
+		{name: "would deny invalid token", client: &stubClient{result: recaptcha.ValidationResult{ErrorCodes: []string{"expired"}}}, expectedStatus: "expired", expectedShadow: "deny"},
+		{name: "would deny provider error", client: &stubClient{err: errUnavailable}, expectedStatus: "timeout", expectedShadow: "deny"},
+		{name: "would challenge", client: scored(0.5), bands: bands, expectedStatus: "challenge", expectedShadow: "deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t, tt.client, func(cfg *config.Config) {
+				cfg.ShadowMode = true
+				cfg.ScoreBands = tt.bands
+			})
+
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token"})
+
+			// Shadow mode reports the decision but never blocks
+			if !response.Allowed {
+				t.Errorf("Expected allowed in shadow mode")
+			}
+			if response.StatusCode != 0 && response.StatusCode != 200 {
+				t.Errorf("Expected no blocking status code, got %d", response.StatusCode)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+			if response.ShadowDecision != tt.expectedShadow {
+				t.Errorf("Expected shadow decision=%q, got %q", tt.expectedShadow, response.ShadowDecision)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_ShadowPolicy(t *testing.T) {
+	svc := newTestService(t, scored(0.2), func(cfg *config.Config) {
+		cfg.Policies = map[string]config.Policy{
+			"observe": {Actions: []string{"authz"}, Threshold: 0.5, FailureMode: "fail_closed", Shadow: true},
+		}
+	})
+
+	tests := []struct {
+		name           string
+		policy         string
+		expectedAllow  bool
+		expectedShadow string
+	}{
+		{name: "enforcing policy", expectedAllow: false},
+		{name: "shadow policy", policy: "observe", expectedAllow: true, expectedShadow: "deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token-" + tt.name, Policy: tt.policy})
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.ShadowDecision != tt.expectedShadow {
+				t.Errorf("Expected shadow decision=%q, got %q", tt.expectedShadow, response.ShadowDecision)
+			}
+		})
+	}
+}
+
+func TestApplyScoreBands(t *testing.T) {
+	bands := &config.ScoreBands{Allow: 0.7, Challenge: 0.3, AllowStatus: 200, ChallengeStatus: 401, DenyStatus: 403}
+
+	tests := []struct {
+		name           string
+		result         *recaptcha.ValidationResult
+		expectedLevel  string
+		expectedCode   int
+		expectedStatus string
+	}{
+		{
+			name:           "at the allow bound",
+			result:         &recaptcha.ValidationResult{Success: true, Score: 0.7, Scored: true},
+			expectedLevel:  RiskLevelLow,
+			expectedCode:   200,
+			expectedStatus: "valid",
+		},
+		{
+			name:           "just below the allow bound",
+			result:         &recaptcha.ValidationResult{Success: true, Score: 0.69, Scored: true},
+			expectedLevel:  RiskLevelMedium,
+			expectedCode:   401,
+			expectedStatus: "challenge",
+		},
+		{
+			name:           "at the challenge bound",
+			result:         &recaptcha.ValidationResult{Success: true, Score: 0.3, Scored: true},
+			expectedLevel:  RiskLevelMedium,
+			expectedCode:   401,
+			expectedStatus: "challenge",
+		},
+		{
+			name:           "below the challenge bound",
+			result:         &recaptcha.ValidationResult{Success: false, Score: 0.29, Scored: true, ErrorCodes: []string{"score-below-threshold"}},
+			expectedLevel:  RiskLevelHigh,
+			expectedCode:   403,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:           "valid without a score",
+			result:         &recaptcha.ValidationResult{Success: true},
+			expectedLevel:  RiskLevelLow,
+			expectedCode:   200,
+			expectedStatus: "valid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &AuthorizationResponse{Allowed: tt.result.IsValidToken(), Status: "valid"}
+			if !tt.result.IsValidToken() {
+				response.Status = tt.result.ErrorCodes[0]
+			}
+
+			applyScoreBands(response, tt.result, bands)
+
+			if response.RiskLevel != tt.expectedLevel || response.StatusCode != tt.expectedCode || response.Status != tt.expectedStatus {
+				t.Errorf("Expected %s/%d/%s, got %s/%d/%s", tt.expectedLevel, tt.expectedCode, tt.expectedStatus,
+					response.RiskLevel, response.StatusCode, response.Status)
+			}
+			if expected := tt.expectedCode < 300; response.Allowed != expected {
+				t.Errorf("Expected allowed=%v, got %v", expected, response.Allowed)
+			}
+		})
+	}
+}
+
+func TestService_Authorize_ZeroScore(t *testing.T) {
+	shadowThreshold := 0.3
+
+	svc := newTestService(t, scored(0.0), func(cfg *config.Config) {
+		cfg.Policies = map[string]config.Policy{
+			"bands": {
+				Actions:     []string{"authz"},
+				FailureMode: "fail_closed",
+				Bands:       &config.ScoreBands{Allow: 0.5, Challenge: 0.0, AllowStatus: 200, ChallengeStatus: 401, DenyStatus: 403},
+			},
+			"observe": {
+				Actions:         []string{"authz"},
+				FailureMode:     "fail_closed",
+				ShadowThreshold: &shadowThreshold,
+			},
+		}
+	})
+
+	tests := []struct {
+		name           string
+		policy         string
+		expectedAllow  bool
+		expectedStatus string
+		expectedRisk   string
+		expectedShadow string
+	}{
+		{name: "below threshold", expectedAllow: false, expectedStatus: "score-below-threshold"},
+		{name: "challenge band", policy: "bands", expectedAllow: false, expectedStatus: "challenge", expectedRisk: RiskLevelMedium},
+		{name: "shadow threshold", policy: "observe", expectedAllow: true, expectedStatus: "valid", expectedShadow: "deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := authorize(t, svc, &AuthorizationRequest{Token: "token-" + tt.name, Policy: tt.policy})
+
+			if response.Allowed != tt.expectedAllow {
+				t.Errorf("Expected allowed=%v, got %v", tt.expectedAllow, response.Allowed)
+			}
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status=%q, got %q", tt.expectedStatus, response.Status)
+			}
+			if response.Score != "0.00" {
+				t.Errorf("Expected score 0.00, got %q", response.Score)
+			}
+			if response.RiskLevel != tt.expectedRisk {
+				t.Errorf("Expected risk level=%q, got %q", tt.expectedRisk, response.RiskLevel)
+			}
+			if response.ShadowDecision != tt.expectedShadow {
+				t.Errorf("Expected shadow decision=%q, got %q", tt.expectedShadow, response.ShadowDecision)
+			}
+		})
+	}
+
+	if count := svc.scores.Distribution().Buckets["0.0"]; count != int64(len(tests)) {
+		t.Errorf("Expected %d scores in the 0.0 bucket, got %d", len(tests), count)
+	}
+}
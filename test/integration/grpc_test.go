@@ -0,0 +1,147 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/prefeitura-rio/app-ext-authz/internal/config"
+	"github.com/prefeitura-rio/app-ext-authz/internal/handlers"
+	"github.com/prefeitura-rio/app-ext-authz/internal/service"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCHandler_Check_Integration(t *testing.T) {
+	cfg := &config.Config{
+		RecaptchaProjectID:             "test-project",
+		RecaptchaSiteKey:               "test_site_key",
+		RecaptchaAction:                "authz",
+		RecaptchaV3Threshold:           0.5,
+		GoogleAPITimeoutSeconds:        5,
+		CacheTTLSeconds:                30,
+		CacheFailedTTLSeconds:          300,
+		RedisURL:                       "redis://localhost:6379",
+		FailureMode:                    "fail_open",
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 5,
+		CircuitBreakerRecoveryTime:     60 * time.Second,
+		HealthCheckIntervalSeconds:     30,
+		OTelServiceName:                "test-service",
+		LogLevel:                       "debug",
+		Port:                           8080,
+		MockMode:                       true,
+		Policies: map[string]config.Policy{
+			"observe": {Actions: []string{"authz"}, Threshold: 0.5, FailureMode: "fail_open", Shadow: true},
+		},
+	}
+
+	svc, err := service.NewService(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	defer svc.Shutdown(context.Background())
+
+	handler := handlers.NewGRPCHandler(svc, handlers.Config{
+		PolicyHeader: "X-Recaptcha-Policy",
+	})
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		extensions     map[string]string
+		expectedCode   codes.Code
+		expectedStatus string
+	}{
+		{
+			name:           "valid token",
+			headers:        map[string]string{"x-recaptcha-token": "valid_token"},
+			expectedCode:   codes.OK,
+			expectedStatus: "valid",
+		},
+		{
+			name:           "low score token",
+			headers:        map[string]string{"x-recaptcha-token": "low_score_token"},
+			expectedCode:   codes.PermissionDenied,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:           "policy from context extensions",
+			headers:        map[string]string{"x-recaptcha-token": "low_score_token"},
+			extensions:     map[string]string{"policy": "observe"},
+			expectedCode:   codes.OK,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:           "policy header is ignored",
+			headers:        map[string]string{"x-recaptcha-token": "low_score_token", "x-recaptcha-policy": "observe"},
+			expectedCode:   codes.PermissionDenied,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "unknown policy",
+			headers:      map[string]string{"x-recaptcha-token": "valid_token"},
+			extensions:   map[string]string{"policy": "missing"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "unknown site key",
+			headers:      map[string]string{"x-recaptcha-token": "valid_token"},
+			extensions:   map[string]string{"site_key": "missing"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "missing token",
+			headers:      map[string]string{},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{
+							Method:  "GET",
+							Path:    "/api/users",
+							Headers: tt.headers,
+						},
+					},
+					ContextExtensions: tt.extensions,
+				},
+			}
+
+			response, err := handler.Check(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if codes.Code(response.GetStatus().GetCode()) != tt.expectedCode {
+				t.Errorf("Expected code=%v, got %v", tt.expectedCode, codes.Code(response.GetStatus().GetCode()))
+			}
+
+			if tt.expectedStatus == "" {
+				return
+			}
+
+			headers := response.GetOkResponse().GetHeaders()
+			if tt.expectedCode != codes.OK {
+				headers = response.GetDeniedResponse().GetHeaders()
+			}
+
+			status := ""
+			for _, h := range headers {
+				if h.GetHeader().GetKey() == "X-Recaptcha-Status" {
+					status = h.GetHeader().GetValue()
+				}
+			}
+
+			if status != tt.expectedStatus {
+				t.Errorf("Expected X-Recaptcha-Status=%v, got %v", tt.expectedStatus, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prefeitura-rio/app-ext-authz/internal/config"
+	"github.com/prefeitura-rio/app-ext-authz/internal/handlers"
+	"github.com/prefeitura-rio/app-ext-authz/internal/service"
+)
+
+type httpTestCase struct {
+	name           string
+	method         string
+	target         string
+	headers        map[string]string
+	expectedCode   int
+	expectedStatus string
+}
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	cfg := &config.Config{
+		RecaptchaProjectID:             "test-project",
+		RecaptchaSiteKey:               "test_site_key",
+		RecaptchaAction:                "authz",
+		RecaptchaV3Threshold:           0.5,
+		GoogleAPITimeoutSeconds:        5,
+		CacheTTLSeconds:                30,
+		CacheFailedTTLSeconds:          300,
+		RedisURL:                       "redis://localhost:6379",
+		FailureMode:                    "fail_open",
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 5,
+		CircuitBreakerRecoveryTime:     60 * time.Second,
+		HealthCheckIntervalSeconds:     30,
+		OTelServiceName:                "test-service",
+		LogLevel:                       "debug",
+		Port:                           8080,
+		MockMode:                       true,
+		Policies: map[string]config.Policy{
+			"observe": {Actions: []string{"authz"}, Threshold: 0.5, FailureMode: "fail_open", Shadow: true},
+		},
+	}
+
+	svc, err := service.NewService(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	t.Cleanup(func() { svc.Shutdown(context.Background()) })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handlers.NewHandler(svc, handlers.Config{
+		PathPrefix:   "/authz",
+		PolicyHeader: "X-Recaptcha-Policy",
+	}).RegisterRoutes(router)
+
+	return router
+}
+
+func runHTTPTestCases(t *testing.T, router *gin.Engine, tests []httpTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected code=%v, got %v", tt.expectedCode, w.Code)
+			}
+
+			if status := w.Header().Get("X-Recaptcha-Status"); status != tt.expectedStatus {
+				t.Errorf("Expected X-Recaptcha-Status=%v, got %v", tt.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestHandler_Authorization_Integration(t *testing.T) {
+	runHTTPTestCases(t, newTestRouter(t), []httpTestCase{
+		{
+			name:           "GET on a subpath",
+			method:         http.MethodGet,
+			target:         "/authz/api/users",
+			headers:        map[string]string{"X-Recaptcha-Token": "valid_token"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "valid",
+		},
+		{
+			name:           "DELETE on the prefix",
+			method:         http.MethodDelete,
+			target:         "/authz",
+			headers:        map[string]string{"X-Recaptcha-Token": "valid_token"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "valid",
+		},
+		{
+			name:           "POST on a nested subpath with a low score",
+			method:         http.MethodPost,
+			target:         "/authz/api/users/42/orders",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token"},
+			expectedCode:   http.StatusForbidden,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "PUT without a token",
+			method:       http.MethodPut,
+			target:       "/authz/api/users",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:           "policy from the header set by Envoy",
+			method:         http.MethodGet,
+			target:         "/authz/login",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token", "X-Recaptcha-Policy": "observe"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "route outside the prefix",
+			method:       http.MethodGet,
+			target:       "/authzx",
+			headers:      map[string]string{"X-Recaptcha-Token": "valid_token"},
+			expectedCode: http.StatusNotFound,
+		},
+	})
+}
+
+func TestHandler_NginxAuth_Integration(t *testing.T) {
+	router := newTestRouter(t)
+
+	runHTTPTestCases(t, router, []httpTestCase{
+		{
+			name:           "valid token",
+			method:         http.MethodGet,
+			target:         "/nginx/auth",
+			headers:        map[string]string{"X-Recaptcha-Token": "valid_token", "X-Original-Method": "POST", "X-Original-URI": "/login"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "valid",
+		},
+		{
+			name:           "low score token",
+			method:         http.MethodGet,
+			target:         "/nginx/auth",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token"},
+			expectedCode:   http.StatusForbidden,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "missing token",
+			method:       http.MethodGet,
+			target:       "/nginx/auth",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "policy from the auth URL",
+			method:         http.MethodGet,
+			target:         "/nginx/auth?policy=observe",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:           "policy header is ignored",
+			method:         http.MethodGet,
+			target:         "/nginx/auth",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token", "X-Recaptcha-Policy": "observe"},
+			expectedCode:   http.StatusForbidden,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "unknown policy",
+			method:       http.MethodGet,
+			target:       "/nginx/auth?policy=missing",
+			headers:      map[string]string{"X-Recaptcha-Token": "valid_token"},
+			expectedCode: http.StatusForbidden,
+		},
+	})
+
+	// The decision's own status is passed on for auth_request_set
+	req := httptest.NewRequest(http.MethodGet, "/nginx/auth", nil)
+	req.Header.Set("X-Recaptcha-Token", "low_score_token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if code := w.Header().Get("X-Recaptcha-Status-Code"); code != "403" {
+		t.Errorf("Expected X-Recaptcha-Status-Code=403, got %v", code)
+	}
+}
+
+func TestHandler_TraefikAuth_Integration(t *testing.T) {
+	runHTTPTestCases(t, newTestRouter(t), []httpTestCase{
+		{
+			name:           "valid token",
+			method:         http.MethodGet,
+			target:         "/traefik/auth",
+			headers:        map[string]string{"X-Recaptcha-Token": "valid_token", "X-Forwarded-Method": "POST", "X-Forwarded-Uri": "/login"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "valid",
+		},
+		{
+			name:           "low score token",
+			method:         http.MethodPost,
+			target:         "/traefik/auth",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token"},
+			expectedCode:   http.StatusForbidden,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "missing token",
+			method:       http.MethodGet,
+			target:       "/traefik/auth",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "policy from the auth URL",
+			method:         http.MethodGet,
+			target:         "/traefik/auth?policy=observe",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token"},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:           "policy header is ignored",
+			method:         http.MethodGet,
+			target:         "/traefik/auth",
+			headers:        map[string]string{"X-Recaptcha-Token": "low_score_token", "X-Recaptcha-Policy": "observe"},
+			expectedCode:   http.StatusForbidden,
+			expectedStatus: "score-below-threshold",
+		},
+		{
+			name:         "unknown site key",
+			method:       http.MethodGet,
+			target:       "/traefik/auth?site_key=missing",
+			headers:      map[string]string{"X-Recaptcha-Token": "valid_token"},
+			expectedCode: http.StatusForbidden,
+		},
+	})
+}